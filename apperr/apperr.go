@@ -0,0 +1,69 @@
+// Package apperr định nghĩa lỗi trả về cho client theo một định dạng thống nhất:
+//
+//	{"error": {"code": "validation_failed", "message": "...", "details": [{"field": "title", "code": "max", "message": "..."}], "request_id": "..."}}
+package apperr
+
+import "net/http"
+
+// FieldError mô tả lỗi của một field trong request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error là lỗi có HTTP status, mã lỗi ổn định cho client và chi tiết theo field (nếu có)
+type Error struct {
+	Status  int          `json:"-"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+	// RequestID được middleware điền khi trả response
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, "bad_request", message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, "not_found", message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, "conflict", message)
+}
+
+func PreconditionFailed(message string) *Error {
+	return New(http.StatusPreconditionFailed, "precondition_failed", message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, "unauthorized", message)
+}
+
+func Unavailable(message string) *Error {
+	return New(http.StatusServiceUnavailable, "unavailable", message)
+}
+
+func Internal(message string) *Error {
+	return New(http.StatusInternalServerError, "internal", message)
+}
+
+// Validation tạo lỗi 400 kèm danh sách field không hợp lệ
+func Validation(details ...FieldError) *Error {
+	return &Error{
+		Status:  http.StatusBadRequest,
+		Code:    "validation_failed",
+		Message: "Request validation failed",
+		Details: details,
+	}
+}
@@ -0,0 +1,211 @@
+// librarytool gom các lệnh bảo trì dữ liệu thư viện gỗ chạy ngoài server.
+//
+//	go run ./cmd/librarytool check-integrity
+//	go run ./cmd/librarytool recompute-sizes
+//	go run ./cmd/librarytool backfill-updated-at
+//	go run ./cmd/librarytool backfill-image-hashes
+//	go run ./cmd/librarytool gc-images [-dry-run] [-grace 72h]
+//	go run ./cmd/librarytool export [-images] [-o backup.zip]
+//	go run ./cmd/librarytool restore [-strategy skip|overwrite|fail] [-upload-images] backup.zip
+//
+// Đặt FIRESTORE_EMULATOR_HOST để chạy với emulator, FIREBASE_PROJECT_ID để trỏ sang project khác.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"backend/config"
+	"backend/firestore"
+	"backend/service"
+	"backend/storage"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: librarytool <command>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  check-integrity        report wood pieces whose database does not exist")
+	fmt.Fprintln(os.Stderr, "  recompute-sizes        recount pieces of every wood database and repair size")
+	fmt.Fprintln(os.Stderr, "  backfill-updated-at    set updated_at on documents created before delta sync")
+	fmt.Fprintln(os.Stderr, "  backfill-image-hashes  compute perceptual hashes and similarity descriptors for older images")
+	fmt.Fprintln(os.Stderr, "  gc-images              delete uploaded images no piece or database has used for the grace period")
+	fmt.Fprintln(os.Stderr, "  export                 write a backup archive of the library")
+	fmt.Fprintln(os.Stderr, "  restore                replay a backup archive into the configured project")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	config.InitFirebase()
+
+	switch os.Args[1] {
+	case "check-integrity":
+		checkIntegrity()
+	case "recompute-sizes":
+		recomputeSizes()
+	case "backfill-updated-at":
+		backfillUpdatedAt()
+	case "backfill-image-hashes":
+		backfillImageHashes()
+	case "gc-images":
+		gcImages(os.Args[2:])
+	case "export":
+		exportLibrary(os.Args[2:])
+	case "restore":
+		restoreLibrary(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// checkIntegrity in ra các piece mồ côi, thoát với mã 1 nếu có
+func checkIntegrity() {
+	orphans, err := service.FindOrphanPieces()
+	if err != nil {
+		log.Fatalf("integrity check failed: %v", err)
+	}
+
+	if len(orphans) == 0 {
+		fmt.Println("no orphaned wood pieces found")
+		return
+	}
+
+	fmt.Printf("found %d orphaned wood pieces:\n", len(orphans))
+	for _, p := range orphans {
+		fmt.Printf("  %s\tdatabase_id=%q\timages=%d\n", p.ID, p.DatabaseID, len(p.ImageUrls))
+	}
+	os.Exit(1)
+}
+
+// recomputeSizes sửa size của các database bị lệch so với số piece thực tế
+func recomputeSizes() {
+	fixes, err := service.RecomputeDatabaseSizes()
+	for _, f := range fixes {
+		fmt.Printf("  %s\tsize %d -> %d\n", f.DatabaseID, f.OldSize, f.NewSize)
+	}
+	if err != nil {
+		log.Fatalf("recompute sizes failed: %v", err)
+	}
+	fmt.Printf("repaired %d wood databases\n", len(fixes))
+}
+
+// backfillUpdatedAt điền updated_at để document cũ xuất hiện trong delta sync
+func backfillUpdatedAt() {
+	n, err := service.BackfillUpdatedAt()
+	if err != nil {
+		log.Fatalf("backfill failed after %d documents: %v", n, err)
+	}
+	fmt.Printf("set updated_at on %d documents\n", n)
+}
+
+// backfillImageHashes tính phash cho ảnh cũ để báo cáo ảnh trùng bao quát cả thư viện
+func backfillImageHashes() {
+	storage.Init()
+	n, err := service.BackfillImageHashes()
+	if err != nil {
+		log.Fatalf("backfill failed after %d images: %v", n, err)
+	}
+	fmt.Printf("hashed %d images\n", n)
+}
+
+// gcImages xóa ảnh mồ côi, -dry-run chỉ in danh sách
+func gcImages(args []string) {
+	fs := flag.NewFlagSet("gc-images", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report orphaned images without writing or deleting anything")
+	grace := fs.Duration("grace", config.ImageGCGrace, "how long an image must stay unreferenced before deletion")
+	fs.Parse(args)
+
+	storage.Init()
+	report, err := service.CollectOrphanImages(*grace, *dryRun)
+	if report != nil {
+		for _, o := range report.Orphans {
+			state := "waiting until " + o.DeleteAfter.Format(time.RFC3339)
+			if o.Expired {
+				state = "expired"
+			}
+			fmt.Printf("  %s\t%d bytes\t%s\t%s\n", o.ImageID, o.Bytes, state, o.URL)
+		}
+	}
+	if err != nil {
+		log.Fatalf("image gc failed: %v", err)
+	}
+	if *dryRun {
+		fmt.Printf("%d of %d images unreferenced (dry run, nothing deleted)\n", len(report.Orphans), report.Scanned)
+		return
+	}
+	fmt.Printf("deleted %d orphaned images, freed %d bytes\n", report.Deleted, report.FreedBytes)
+}
+
+// exportLibrary ghi archive backup ra file
+func exportLibrary(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	images := fs.Bool("images", false, "download image files into the archive")
+	out := fs.String("o", fmt.Sprintf("wood-library-%s.zip", time.Now().UTC().Format("20060102-150405")), "output file")
+	fs.Parse(args)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+	manifest, err := service.ExportLibrary(f, *images)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*out)
+		log.Fatalf("export failed: %v", err)
+	}
+
+	fmt.Printf("exported %d wood databases, %d pieces, %d images to %s\n",
+		manifest.Databases, manifest.Pieces, len(manifest.Images), *out)
+	if len(manifest.MissingImages) > 0 {
+		fmt.Printf("warning: %d images could not be downloaded (listed in manifest.json)\n", len(manifest.MissingImages))
+	}
+}
+
+// restoreLibrary khôi phục archive backup vào project đang cấu hình
+func restoreLibrary(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	strategy := fs.String("strategy", string(firestore.ConflictFail), "what to do with documents that already exist: skip, overwrite or fail")
+	uploadImages := fs.Bool("upload-images", false, "upload images stored in the archive to the blob store and rewrite their URLs")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: librarytool restore [-strategy skip|overwrite|fail] [-upload-images] <archive.zip>")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("restore failed: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatalf("restore failed: %v", err)
+	}
+
+	if *uploadImages {
+		storage.Init()
+	}
+
+	report, err := service.RestoreLibrary(f, info.Size(), service.RestoreOptions{
+		Strategy:     firestore.ConflictStrategy(*strategy),
+		UploadImages: *uploadImages,
+	})
+	if report != nil {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		log.Fatalf("restore failed: %v", err)
+	}
+}
@@ -1,7 +1,113 @@
 package config
 
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
 const (
 	ModelDir   = "./models"
 	ServerPort = ":8080"
 	ModelName  = "yolo11n"
 )
+
+var (
+	// Thời gian giữ item trong thùng rác trước khi bị xóa vĩnh viễn
+	TrashRetention = time.Duration(envInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+	// Chu kỳ chạy job dọn thùng rác
+	TrashPurgeInterval = envDuration("TRASH_PURGE_INTERVAL", time.Hour)
+
+	// Dung lượng tối đa của một file ảnh upload
+	ImageMaxBytes = int64(envInt("IMAGE_MAX_MB", 10)) << 20
+	// Cạnh dài nhất và tổng số pixel tối đa của ảnh upload, chặn ảnh nén nhỏ nhưng giải nén ra rất lớn
+	ImageMaxDimension = envInt("IMAGE_MAX_DIMENSION", 12000)
+	ImageMaxPixels    = int64(envInt("IMAGE_MAX_MEGAPIXELS", 50)) * 1000 * 1000
+	// Lưu thời điểm chụp và máy ảnh đọc từ EXIF vào bản ghi ảnh (metadata gốc luôn bị bỏ khỏi file)
+	ImageCaptureMetadata = envBool("IMAGE_CAPTURE_METADATA", true)
+	// Số chữ số thập phân giữ lại của tọa độ khi người upload đồng ý lưu vị trí (1 ≈ 11km)
+	ImageLocationDecimals = envInt("IMAGE_LOCATION_DECIMALS", 1)
+	// Xử lý ảnh gần trùng khi upload: off, warn (mặc định, vẫn lưu và báo lại) hoặc reject (409)
+	ImageDuplicatePolicy = envString("IMAGE_DUPLICATE_POLICY", "warn")
+	// Số bit khác nhau tối đa giữa hai perceptual hash để coi là gần trùng (0-64)
+	ImageDuplicateDistance = envInt("IMAGE_DUPLICATE_DISTANCE", 6)
+	// Số file tối đa và số file xử lý song song khi upload nhiều ảnh vào một piece
+	ImageUploadMaxFiles    = envInt("IMAGE_UPLOAD_MAX_FILES", 20)
+	ImageUploadConcurrency = envInt("IMAGE_UPLOAD_CONCURRENCY", 3)
+	// Thời hạn của tham số upload trực tiếp lên blob store (Cloudinary giới hạn tối đa 1 giờ)
+	DirectUploadTTL = envDuration("DIRECT_UPLOAD_TTL", 15*time.Minute)
+	// Ảnh không được piece/database nào dùng lâu hơn thời gian này bị job GC xóa
+	ImageGCGrace = envDuration("IMAGE_GC_GRACE", 72*time.Hour)
+	// Chu kỳ chạy job GC ảnh, 0 để tắt (vẫn chạy tay được qua API hoặc librarytool)
+	ImageGCInterval = envDuration("IMAGE_GC_INTERVAL", 6*time.Hour)
+	// Số piece trả về mặc định và tối đa của tìm ảnh tương tự
+	SimilarSearchLimit    = envInt("SIMILAR_SEARCH_LIMIT", 10)
+	SimilarSearchMaxLimit = envInt("SIMILAR_SEARCH_MAX_LIMIT", 50)
+
+	// Dung lượng tối đa của file import (kể cả ảnh trong ZIP)
+	ImportMaxBytes = int64(envInt("IMPORT_MAX_MB", 50)) << 20
+	// Số dòng dữ liệu tối đa trong một lần import
+	ImportMaxRows = envInt("IMPORT_MAX_ROWS", 5000)
+
+	// Thời gian giữ tombstone của document đã xóa vĩnh viễn; client có sync token cũ hơn phải đồng bộ lại toàn bộ
+	TombstoneRetention = time.Duration(envInt("TOMBSTONE_RETENTION_DAYS", 90)) * 24 * time.Hour
+
+	// Thời gian giữ offline bundle đã build trong bộ nhớ
+	OfflineBundleTTL = envDuration("OFFLINE_BUNDLE_TTL", 10*time.Minute)
+
+	// Số event gần nhất được giữ lại để client kết nối lại change feed đọc tiếp theo Last-Event-ID
+	ChangeFeedBuffer = envInt("CHANGE_FEED_BUFFER", 1000)
+	// Chu kỳ gửi heartbeat trên change feed để proxy không đóng kết nối rảnh
+	ChangeFeedHeartbeat = envDuration("CHANGE_FEED_HEARTBEAT", 25*time.Second)
+)
+
+// envString đọc biến môi trường kiểu chuỗi, trả giá trị mặc định nếu không có
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt đọc biến môi trường kiểu số nguyên, trả giá trị mặc định nếu không có hoặc sai định dạng
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// envBool đọc biến môi trường kiểu bool ("true", "1", "false", "0"...)
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// envDuration đọc biến môi trường dạng time.Duration (vd "30m", "2h")
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %s", key, v, def)
+		return def
+	}
+	return d
+}
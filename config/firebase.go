@@ -11,27 +11,35 @@ import (
 
 var FirebaseApp *firebase.App
 
+// InitFirebase khởi tạo Firebase app. Khi FIRESTORE_EMULATOR_HOST được đặt (vd "localhost:8080"),
+// thư viện Firestore tự kết nối tới emulator nên không cần credentials.
+// FIREBASE_PROJECT_ID cho phép trỏ sang project khác (vd khi khôi phục backup).
 func InitFirebase() {
 	ctx := context.Background()
 
-	// For VPS
-	credPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-
-	// For local testing
-	//credPath := "./serviceAccount.json"
-
-	if credPath == "" {
-		log.Fatal("GOOGLE_APPLICATION_CREDENTIALS is not set")
-	}
-
-	opt := option.WithCredentialsFile(credPath)
-
 	config := &firebase.Config{
-		ProjectID:     "swin-55203",
+		ProjectID:     envString("FIREBASE_PROJECT_ID", "swin-55203"),
 		StorageBucket: "swin-55203.appspot.com",
 	}
 
-	app, err := firebase.NewApp(ctx, config, opt)
+	var opts []option.ClientOption
+	if emulator := os.Getenv("FIRESTORE_EMULATOR_HOST"); emulator != "" {
+		log.Printf("Using Firestore emulator at %s", emulator)
+		opts = append(opts, option.WithoutAuthentication())
+	} else {
+		// For VPS
+		credPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+
+		// For local testing
+		//credPath := "./serviceAccount.json"
+
+		if credPath == "" {
+			log.Fatal("GOOGLE_APPLICATION_CREDENTIALS is not set")
+		}
+		opts = append(opts, option.WithCredentialsFile(credPath))
+	}
+
+	app, err := firebase.NewApp(ctx, config, opts...)
 	if err != nil {
 		log.Fatalf("error initializing firebase: %v", err)
 	}
@@ -0,0 +1,23 @@
+package config
+
+// Cấu hình nơi lưu ảnh và file model. BLOB_BACKEND chọn một trong:
+//   - cloudinary (mặc định)
+//   - local: lưu trên đĩa, server tự phục vụ file tại /blobs, tiện chạy offline khi phát triển
+//   - s3: S3 hoặc dịch vụ tương thích (MinIO, R2...), truy cập theo path-style
+var (
+	BlobBackend = envString("BLOB_BACKEND", "cloudinary")
+
+	// Thư mục gốc và URL công khai của backend local
+	BlobLocalDir  = envString("BLOB_LOCAL_DIR", "./data/blobs")
+	BlobPublicURL = envString("BLOB_PUBLIC_URL", "http://localhost"+ServerPort+"/blobs")
+	// Khóa ký URL tạm thời của backend local, để trống thì sinh ngẫu nhiên mỗi lần khởi động
+	BlobSigningKey = envString("BLOB_SIGNING_KEY", "")
+
+	S3Endpoint        = envString("S3_ENDPOINT", "https://s3.amazonaws.com")
+	S3Region          = envString("S3_REGION", "us-east-1")
+	S3Bucket          = envString("S3_BUCKET", "")
+	S3AccessKeyID     = envString("S3_ACCESS_KEY_ID", "")
+	S3SecretAccessKey = envString("S3_SECRET_ACCESS_KEY", "")
+	// URL công khai của bucket (vd CDN) dùng cho URL lưu trong dữ liệu, mặc định <endpoint>/<bucket>
+	S3PublicURL = envString("S3_PUBLIC_URL", "")
+)
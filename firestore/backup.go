@@ -0,0 +1,125 @@
+package firestore
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ConflictStrategy quyết định cách xử lý document trong bản backup đã tồn tại khi khôi phục
+type ConflictStrategy string
+
+const (
+	ConflictSkip      ConflictStrategy = "skip"      // giữ document hiện có
+	ConflictOverwrite ConflictStrategy = "overwrite" // ghi đè bằng dữ liệu trong backup
+	ConflictFail      ConflictStrategy = "fail"      // dừng nếu có document trùng, không ghi gì
+)
+
+// getAllChunkSize giới hạn số document đọc trong một lần GetAll
+const getAllChunkSize = 300
+
+// ExistingDocumentIDs trả về các ID trong ids đã có document trong collection
+func ExistingDocumentIDs(collection string, ids []string) ([]string, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	var existing []string
+	coll := client.Collection(collection)
+	for start := 0; start < len(ids); start += getAllChunkSize {
+		chunk := ids[start:min(start+getAllChunkSize, len(ids))]
+		refs := make([]*firestore.DocumentRef, len(chunk))
+		for i, id := range chunk {
+			refs[i] = coll.Doc(id)
+		}
+
+		snaps, err := client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, mapError(err)
+		}
+		for _, snap := range snaps {
+			if snap.Exists() {
+				existing = append(existing, snap.Ref.ID)
+			}
+		}
+	}
+	return existing, nil
+}
+
+// RestoreDocuments ghi lại các document từ bản backup bằng BulkWriter. Với ConflictOverwrite dùng Set,
+// còn lại dùng Create và bỏ qua document đã tồn tại. ids[i] là ID của docs[i].
+// Trả về số document đã ghi và số bị bỏ qua.
+func RestoreDocuments[T any](collection string, ids []string, docs []T, strategy ConflictStrategy) (written, skipped int, err error) {
+	if len(docs) == 0 {
+		return 0, 0, nil
+	}
+
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	coll := client.Collection(collection)
+	bw := client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(docs))
+	for i := range docs {
+		var job *firestore.BulkWriterJob
+		if strategy == ConflictOverwrite {
+			job, err = bw.Set(coll.Doc(ids[i]), &docs[i])
+		} else {
+			job, err = bw.Create(coll.Doc(ids[i]), &docs[i])
+		}
+		if err != nil {
+			bw.End()
+			return written, skipped, err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	for _, job := range jobs {
+		_, err := job.Results()
+		if status.Code(err) == codes.AlreadyExists {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return written, skipped, mapError(err)
+		}
+		written++
+	}
+	return written, skipped, nil
+}
+
+// RaisePieceCounter đảm bảo counter của database không nhỏ hơn minIndex,
+// để piece tạo sau khi khôi phục không trùng ID với piece trong bản backup
+func RaisePieceCounter(databaseID string, minIndex int) error {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	counterRef := client.Collection(pieceCounterCollection).Doc(databaseID)
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(counterRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var counter pieceCounter
+			if err := snap.DataTo(&counter); err != nil {
+				return err
+			}
+			if counter.LastIndex >= minIndex {
+				return nil
+			}
+		}
+		return tx.Set(counterRef, pieceCounter{LastIndex: minIndex})
+	})
+	return mapError(err)
+}
+
+// PieceIndex lấy số thứ tự của piece từ ID dạng <db>_NN
+func PieceIndex(databaseID, pieceID string) (int, bool) {
+	return parsePieceIndex(databaseID, pieceID)
+}
@@ -0,0 +1,70 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Các loại lỗi của tầng dữ liệu, tầng HTTP dùng errors.Is để chọn status trả về
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrPrecondition = errors.New("precondition failed")
+	ErrUnavailable  = errors.New("datastore unavailable")
+)
+
+// Error là lỗi cụ thể của tầng dữ liệu. Kind là một trong các loại lỗi ở trên,
+// Cause (nếu có) là lỗi gốc từ Firestore, chỉ dùng để ghi log chứ không trả cho client.
+type Error struct {
+	Kind  error
+	Msg   string
+	Cause error
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Cause}
+}
+
+func newError(kind error, msg string) *Error {
+	return &Error{Kind: kind, Msg: msg}
+}
+
+// mapError phân loại lỗi gRPC trả về từ Firestore theo status code.
+// Lỗi đã được phân loại hoặc không thuộc loại nào được trả lại nguyên vẹn.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: ErrUnavailable, Msg: "datastore request timed out", Cause: err}
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return &Error{Kind: ErrNotFound, Msg: "document not found", Cause: err}
+	case codes.AlreadyExists:
+		return &Error{Kind: ErrConflict, Msg: "document already exists", Cause: err}
+	case codes.Aborted:
+		// Transaction bị hủy do ghi đồng thời, client có thể thử lại
+		return &Error{Kind: ErrConflict, Msg: "document was modified concurrently", Cause: err}
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return &Error{Kind: ErrUnavailable, Msg: "datastore is temporarily unavailable", Cause: err}
+	}
+	// FailedPrecondition cũng dùng cho query thiếu index nên không coi là lỗi của client;
+	// lỗi precondition do If-Match được phân loại riêng ở mapPreconditionError
+	return err
+}
@@ -1,13 +1,15 @@
 package firestore
 
 import (
-	"backend/models"
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"backend/config"
 )
@@ -28,16 +30,12 @@ func CreateDocument(collection, docID string, data interface{}) error {
 	client := getClient(ctx)
 	defer client.Close()
 
-	docRef := client.Collection(collection).Doc(docID)
-
-	// Kiểm tra document đã tồn tại chưa
-	_, err := docRef.Get(ctx)
-	if err == nil {
-		return fmt.Errorf("document with ID '%s' already exists", docID)
+	// Create chỉ ghi khi document chưa tồn tại, kiểm tra và ghi là một thao tác nguyên tử
+	_, err := client.Collection(collection).Doc(docID).Create(ctx, data)
+	if status.Code(err) == codes.AlreadyExists {
+		return &Error{Kind: ErrConflict, Msg: fmt.Sprintf("document with ID '%s' already exists", docID), Cause: err}
 	}
-
-	_, err = docRef.Set(ctx, data)
-	return err
+	return mapError(err)
 }
 
 // UpdateDocument cập nhật document, trả lỗi nếu không tồn tại
@@ -50,12 +48,26 @@ func UpdateDocument(collection, docID string, data interface{}) error {
 
 	// Kiểm tra document có tồn tại không
 	_, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &Error{Kind: ErrNotFound, Msg: fmt.Sprintf("document with ID '%s' not found", docID), Cause: err}
+	}
 	if err != nil {
-		return fmt.Errorf("document with ID '%s' not found", docID)
+		return mapError(err)
 	}
 
 	_, err = docRef.Set(ctx, data)
-	return err
+	return mapError(err)
+}
+
+// UpdateFields ghi một số field của document (giá trị nil xóa field), trả ErrNotFound nếu document không tồn tại.
+// Không tự cập nhật updated_at, dùng cho collection không tham gia delta sync.
+func UpdateFields(collection, docID string, fields map[string]interface{}) error {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	_, err := client.Collection(collection).Doc(docID).Update(ctx, setUpdates(fields))
+	return mapError(err)
 }
 
 // SetDocument ghi dữ liệu vào document (overwrite nếu tồn tại) - giữ lại cho backward compatibility
@@ -65,7 +77,7 @@ func SetDocument(collection, docID string, data interface{}) error {
 	defer client.Close()
 
 	_, err := client.Collection(collection).Doc(docID).Set(ctx, data)
-	return err
+	return mapError(err)
 }
 
 // AddDocument thêm document mới với ID tự sinh
@@ -76,7 +88,7 @@ func AddDocument(collection string, data interface{}) (string, error) {
 
 	docRef, _, err := client.Collection(collection).Add(ctx, data)
 	if err != nil {
-		return "", err
+		return "", mapError(err)
 	}
 	return docRef.ID, nil
 }
@@ -88,128 +100,119 @@ func DocumentExists(collection, docID string) (bool, error) {
 	defer client.Close()
 
 	_, err := client.Collection(collection).Doc(docID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
 	if err != nil {
-		if err == iterator.Done || isNotFoundError(err) {
-			return false, nil
-		}
-		return false, err
+		return false, mapError(err)
 	}
 	return true, nil
 }
 
 // -------------------- READ --------------------
 
-// GetDocument đọc document theo ID
-func GetDocument(collection, docID string) (map[string]interface{}, error) {
+// Get đọc document theo ID và decode vào kiểu T, kèm update time (dùng làm ETag)
+func Get[T any](collection, docID string) (*T, time.Time, error) {
 	ctx := context.Background()
 	client := getClient(ctx)
 	defer client.Close()
 
 	doc, err := client.Collection(collection).Doc(docID).Get(ctx)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, mapError(err)
 	}
-	return doc.Data(), nil
+
+	var v T
+	if err := doc.DataTo(&v); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &v, doc.UpdateTime, nil
 }
 
-// GetCollection đọc toàn bộ document trong collection
-func GetCollection(collection string) ([]map[string]interface{}, error) {
+// List đọc toàn bộ document trong collection (kể cả document đã xóa mềm)
+func List[T any](collection string) ([]T, error) {
 	ctx := context.Background()
 	client := getClient(ctx)
 	defer client.Close()
 
-	iter := client.Collection(collection).Documents(ctx)
-	defer iter.Stop()
-
-	var results []map[string]interface{}
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, doc.Data())
-	}
-	return results, nil
+	return queryAll[T](ctx, client.Collection(collection).Query)
 }
 
 // PaginationParams chứa các tham số phân trang
 type PaginationParams struct {
-	Limit      int
-	Offset     int
-	OrderBy    string
-	Descending bool
+	Limit          int
+	Offset         int
+	OrderBy        string
+	Descending     bool
+	IncludeDeleted bool // mặc định bỏ qua các document đã bị xóa mềm
 }
 
-// PaginatedResult chứa kết quả phân trang
-type PaginatedResult struct {
-	Data    []map[string]interface{} `json:"data"`
-	Total   int                      `json:"total"`
-	Limit   int                      `json:"limit"`
-	Offset  int                      `json:"offset"`
-	HasMore bool                     `json:"has_more"`
+// Page chứa kết quả phân trang
+type Page[T any] struct {
+	Data    []T  `json:"data"`
+	Total   int  `json:"total"`
+	Limit   int  `json:"limit"`
+	Offset  int  `json:"offset"`
+	HasMore bool `json:"has_more"`
 }
 
-// GetCollectionPaginated đọc collection với phân trang
-func GetCollectionPaginated(collection string, params PaginationParams) (*PaginatedResult, error) {
+// ListPage đọc collection với phân trang
+func ListPage[T any](collection string, params PaginationParams) (*Page[T], error) {
 	ctx := context.Background()
 	client := getClient(ctx)
 	defer client.Close()
 
-	collRef := client.Collection(collection)
+	return queryPage[T](ctx, client.Collection(collection).Query, params)
+}
 
-	// Đếm tổng số documents
-	allDocs := collRef.Documents(ctx)
-	total := 0
-	for {
-		_, err := allDocs.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		total++
-	}
-	allDocs.Stop()
+// ListPageWhere đọc collection với filter field == value và phân trang
+func ListPageWhere[T any](collection, field string, value interface{}, params PaginationParams) (*Page[T], error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
 
-	// Query với phân trang
-	var query firestore.Query
+	return queryPage[T](ctx, client.Collection(collection).Where(field, "==", value), params)
+}
+
+// queryPage duyệt query một lần để vừa đếm tổng vừa lấy trang cần trả về.
+// Document đã xóa mềm bị lọc trong lúc duyệt nên total và offset chỉ tính document còn hiệu lực.
+func queryPage[T any](ctx context.Context, query firestore.Query, params PaginationParams) (*Page[T], error) {
 	if params.OrderBy != "" {
 		if params.Descending {
-			query = collRef.OrderBy(params.OrderBy, firestore.Desc)
+			query = query.OrderBy(params.OrderBy, firestore.Desc)
 		} else {
-			query = collRef.OrderBy(params.OrderBy, firestore.Asc)
+			query = query.OrderBy(params.OrderBy, firestore.Asc)
 		}
-	} else {
-		query = collRef.Query
-	}
-
-	if params.Offset > 0 {
-		query = query.Offset(params.Offset)
-	}
-	if params.Limit > 0 {
-		query = query.Limit(params.Limit)
 	}
 
 	iter := query.Documents(ctx)
 	defer iter.Stop()
 
-	var results []map[string]interface{}
+	results := []T{}
+	total := 0
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, mapError(err)
 		}
-		results = append(results, doc.Data())
+		if !params.IncludeDeleted && isDeleted(doc) {
+			continue
+		}
+
+		if total >= params.Offset && (params.Limit <= 0 || len(results) < params.Limit) {
+			var v T
+			if err := doc.DataTo(&v); err != nil {
+				return nil, err
+			}
+			results = append(results, v)
+		}
+		total++
 	}
 
-	return &PaginatedResult{
+	return &Page[T]{
 		Data:    results,
 		Total:   total,
 		Limit:   params.Limit,
@@ -218,71 +221,33 @@ func GetCollectionPaginated(collection string, params PaginationParams) (*Pagina
 	}, nil
 }
 
-// GetCollectionWithFilter đọc collection với filter và phân trang
-func GetCollectionWithFilter(collection, filterField, filterValue string, params PaginationParams) (*PaginatedResult, error) {
-	ctx := context.Background()
-	client := getClient(ctx)
-	defer client.Close()
-
-	collRef := client.Collection(collection)
-
-	// Query với filter
-	baseQuery := collRef.Where(filterField, "==", filterValue)
-
-	// Đếm tổng số documents với filter
-	allDocs := baseQuery.Documents(ctx)
-	total := 0
-	for {
-		_, err := allDocs.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		total++
-	}
-	allDocs.Stop()
-
-	// Query với phân trang
-	var query firestore.Query = baseQuery
-	if params.OrderBy != "" {
-		if params.Descending {
-			query = query.OrderBy(params.OrderBy, firestore.Desc)
-		} else {
-			query = query.OrderBy(params.OrderBy, firestore.Asc)
-		}
-	}
-
-	if params.Offset > 0 {
-		query = query.Offset(params.Offset)
-	}
-	if params.Limit > 0 {
-		query = query.Limit(params.Limit)
-	}
-
+// queryAll đọc và decode toàn bộ kết quả của query
+func queryAll[T any](ctx context.Context, query firestore.Query) ([]T, error) {
 	iter := query.Documents(ctx)
 	defer iter.Stop()
 
-	var results []map[string]interface{}
+	results := []T{}
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
+			return nil, mapError(err)
+		}
+		var v T
+		if err := doc.DataTo(&v); err != nil {
 			return nil, err
 		}
-		results = append(results, doc.Data())
+		results = append(results, v)
 	}
+	return results, nil
+}
 
-	return &PaginatedResult{
-		Data:    results,
-		Total:   total,
-		Limit:   params.Limit,
-		Offset:  params.Offset,
-		HasMore: params.Offset+len(results) < total,
-	}, nil
+// isDeleted kiểm tra document đã bị xóa mềm (có deleted_at) hay chưa
+func isDeleted(doc *firestore.DocumentSnapshot) bool {
+	v, err := doc.DataAt("deleted_at")
+	return err == nil && v != nil
 }
 
 // DeleteDocument xóa document theo collection và docID
@@ -292,39 +257,36 @@ func DeleteDocument(collection, docID string) error {
 	defer client.Close()
 
 	_, err := client.Collection(collection).Doc(docID).Delete(ctx)
-	return err
+	return mapError(err)
 }
 
-func isNotFoundError(err error) bool {
-	return err != nil && (err.Error() == "rpc error: code = NotFound desc = Document not found" ||
-		err.Error() == "rpc error: code = NotFound desc = No document to update")
+// GetDocumentsByField lấy tất cả documents của collection theo field = value
+func GetDocumentsByField[T any](collection, field string, value interface{}) ([]T, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	return queryAll[T](ctx, client.Collection(collection).Where(field, "==", value))
 }
 
-// GetDocumentsByField lấy tất cả documents của collection theo field = value
-func GetDocumentsByField(collection, field string, value interface{}) ([]models.WoodPiece, error) {
+// ListDocumentIDs lấy ID của toàn bộ document trong collection
+func ListDocumentIDs(collection string) ([]string, error) {
 	ctx := context.Background()
 	client := getClient(ctx)
 	defer client.Close()
 
-	iter := client.Collection(collection).Where(field, "==", value).Documents(ctx)
-	defer iter.Stop()
+	iter := client.Collection(collection).DocumentRefs(ctx)
 
-	var results []models.WoodPiece
+	var ids []string
 	for {
-		doc, err := iter.Next()
-		if err != nil {
-			if err == iterator.Done {
-				break
-			}
-			return nil, err
+		ref, err := iter.Next()
+		if err == iterator.Done {
+			break
 		}
-
-		var p models.WoodPiece
-		if err := doc.DataTo(&p); err != nil {
-			return nil, err
+		if err != nil {
+			return nil, mapError(err)
 		}
-		results = append(results, p)
+		ids = append(ids, ref.ID)
 	}
-
-	return results, nil
+	return ids, nil
 }
@@ -0,0 +1,116 @@
+package firestore
+
+import (
+	"backend/models"
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// importChunkSize giới hạn số piece ghi trong một transaction
+// (Firestore cho phép tối đa 500 thao tác ghi, còn chừa chỗ cho counter và database cha)
+const importChunkSize = 400
+
+// CreateWoodDatabases tạo nhiều database bằng BulkWriter, trả về lỗi của từng database theo thứ tự đầu vào
+// (nil nếu tạo thành công). Như CreateWoodDatabase, ID đã tồn tại trả ErrDatabaseExists.
+func CreateWoodDatabases(dbs []*models.WoodDatabase, createdBy string) []error {
+	errs := make([]error, len(dbs))
+	if len(dbs) == 0 {
+		return errs
+	}
+
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+	coll := client.Collection(woodDatabaseCollection)
+
+	bw := client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, len(dbs))
+	for i, db := range dbs {
+		prepareNewWoodDatabase(db, createdBy, createdAt)
+
+		jobs[i], errs[i] = bw.Create(coll.Doc(db.ID), db)
+	}
+	bw.End()
+
+	for i, job := range jobs {
+		if job == nil {
+			errs[i] = mapError(errs[i])
+			continue
+		}
+		wr, err := job.Results()
+		if status.Code(err) == codes.AlreadyExists {
+			errs[i] = ErrDatabaseExists
+			continue
+		}
+		if err != nil {
+			errs[i] = mapError(err)
+			continue
+		}
+		dbs[i].UpdatedAt = wr.UpdateTime
+	}
+	return errs
+}
+
+// CreateWoodPieces tạo nhiều piece cho cùng một database. Mỗi nhóm tối đa importChunkSize piece
+// được cấp một dải ID liên tiếp từ counter, ghi và tăng size của database trong cùng một transaction,
+// nên một nhóm hoặc được ghi toàn bộ hoặc không ghi gì. Dừng ở nhóm lỗi đầu tiên và trả về
+// số piece đã tạo (các piece đầu danh sách). Trả ErrDatabaseNotFound nếu database không hoạt động.
+func CreateWoodPieces(databaseID string, pieces []*models.WoodPiece, createdBy string) (created int, err error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(databaseID)
+	counterRef := client.Collection(pieceCounterCollection).Doc(databaseID)
+	coll := client.Collection(woodPieceCollection)
+
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+	for _, piece := range pieces {
+		piece.DatabaseID = databaseID
+		prepareNewWoodPiece(piece, createdBy, createdAt)
+	}
+
+	for start := 0; start < len(pieces); start += importChunkSize {
+		chunk := pieces[start:min(start+importChunkSize, len(pieces))]
+
+		var commit firestore.CommitResponse
+		err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			if _, err := getWoodDatabaseInTx(tx, dbRef); err != nil {
+				return err
+			}
+			lastIndex, err := readLastPieceIndex(tx, client, counterRef, databaseID)
+			if err != nil {
+				return err
+			}
+
+			for i, piece := range chunk {
+				piece.ID = formatPieceID(databaseID, lastIndex+1+i)
+				if err := tx.Create(coll.Doc(piece.ID), piece); err != nil {
+					return err
+				}
+			}
+			if err := tx.Update(dbRef, sizeDelta(len(chunk))); err != nil {
+				return err
+			}
+			return tx.Set(counterRef, pieceCounter{LastIndex: lastIndex + len(chunk)})
+		}, firestore.WithCommitResponseTo(&commit))
+		if err != nil {
+			for _, piece := range chunk {
+				piece.ID = ""
+			}
+			return created, mapError(err)
+		}
+
+		for _, piece := range chunk {
+			piece.UpdatedAt = commit.CommitTime()
+		}
+		created += len(chunk)
+	}
+	return created, nil
+}
@@ -0,0 +1,146 @@
+package firestore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// tombstoneCollection lưu dấu vết của document bị xóa vĩnh viễn để client đồng bộ biết mà xóa theo
+const tombstoneCollection = "tombstone"
+
+// Tombstone ghi lại một document đã bị xóa vĩnh viễn
+type Tombstone struct {
+	Collection string    `json:"collection" firestore:"collection"`
+	DocID      string    `json:"doc_id" firestore:"doc_id"`
+	DeletedAt  time.Time `json:"deleted_at" firestore:"deleted_at,serverTimestamp"`
+}
+
+func tombstoneRef(client *firestore.Client, ref *firestore.DocumentRef) *firestore.DocumentRef {
+	return client.Collection(tombstoneCollection).Doc(ref.Parent.ID + ":" + ref.ID)
+}
+
+func newTombstone(ref *firestore.DocumentRef) Tombstone {
+	return Tombstone{Collection: ref.Parent.ID, DocID: ref.ID}
+}
+
+// bulkTombstone ghi tombstone cho các document sắp bị xóa vĩnh viễn. Tombstone được ghi trước khi xóa
+// để nếu xóa lỗi giữa chừng thì client chỉ xóa thừa item vốn đã nằm trong thùng rác.
+func bulkTombstone(ctx context.Context, client *firestore.Client, refs []*firestore.DocumentRef) error {
+	return bulkWrite(ctx, client, refs, func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+		return bw.Set(tombstoneRef(client, ref), newTombstone(ref))
+	})
+}
+
+// SyncCursor là vị trí đã đọc tới trong một collection, theo thứ tự (thời điểm, document ID).
+// ID rỗng nghĩa là đọc các document có thời điểm sau Time.
+type SyncCursor struct {
+	Time time.Time `json:"t"`
+	ID   string    `json:"id,omitempty"`
+}
+
+// Change là một document có thời điểm sau cursor
+type Change[T any] struct {
+	ID   string
+	Time time.Time
+	Doc  T
+}
+
+// ChangesSince đọc tối đa limit document của collection có timeField sau cursor, sắp xếp theo (timeField, ID).
+// Document không có timeField (dữ liệu cũ chưa backfill) không được trả về.
+func ChangesSince[T any](collection, timeField string, cursor SyncCursor, limit int) ([]Change[T], error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	query := client.Collection(collection).
+		OrderBy(timeField, firestore.Asc).
+		OrderBy(firestore.DocumentID, firestore.Asc)
+	switch {
+	case cursor.ID != "":
+		query = query.StartAfter(cursor.Time, cursor.ID)
+	case !cursor.Time.IsZero():
+		query = query.Where(timeField, ">", cursor.Time)
+	}
+
+	iter := query.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+
+	changes := []Change[T]{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		t, _ := doc.DataAt(timeField)
+		change := Change[T]{ID: doc.Ref.ID}
+		change.Time, _ = t.(time.Time)
+		if err := doc.DataTo(&change.Doc); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// ListTombstonesSince đọc tombstone sau cursor, dùng cho delta sync
+func ListTombstonesSince(cursor SyncCursor, limit int) ([]Change[Tombstone], error) {
+	return ChangesSince[Tombstone](tombstoneCollection, "deleted_at", cursor, limit)
+}
+
+// PurgeTombstones xóa tombstone cũ hơn before, trả về số tombstone đã xóa.
+// Client có token cũ hơn mốc này phải đồng bộ lại toàn bộ.
+func PurgeTombstones(before time.Time) (int, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	refs, err := client.Collection(tombstoneCollection).Where("deleted_at", "<", before).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, mapError(err)
+	}
+	docRefs := make([]*firestore.DocumentRef, len(refs))
+	for i, snap := range refs {
+		docRefs[i] = snap.Ref
+	}
+	if err := bulkDelete(ctx, client, docRefs); err != nil {
+		return 0, mapError(err)
+	}
+	return len(docRefs), nil
+}
+
+// BackfillUpdatedAt điền updated_at cho các document chưa có (dữ liệu tạo trước khi có delta sync),
+// để chúng xuất hiện trong lần đồng bộ tiếp theo. Trả về số document đã cập nhật.
+func BackfillUpdatedAt(collection string) (int, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	iter := client.Collection(collection).Documents(ctx)
+	defer iter.Stop()
+
+	var refs []*firestore.DocumentRef
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, mapError(err)
+		}
+		if v, err := doc.DataAt("updated_at"); err != nil || v == nil {
+			refs = append(refs, doc.Ref)
+		}
+	}
+
+	if err := bulkUpdate(ctx, client, refs, []firestore.Update{touch()}); err != nil {
+		return 0, mapError(err)
+	}
+	return len(refs), nil
+}
@@ -0,0 +1,536 @@
+package firestore
+
+import (
+	"backend/models"
+	"context"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const woodDatabaseCollection = "wood_database"
+
+var (
+	ErrDatabaseNotFound  = newError(ErrNotFound, "wood database not found")
+	ErrDatabaseExists    = newError(ErrConflict, "wood database already exists")
+	ErrDatabaseHasPieces = newError(ErrConflict, "wood database still has pieces")
+	ErrNotInTrash        = newError(ErrConflict, "document is not in trash")
+	// ErrPreconditionFailed trả về khi document đã bị sửa sau phiên bản client gửi lên (If-Match)
+	ErrPreconditionFailed = newError(ErrPrecondition, "document was modified since it was read")
+)
+
+// getWoodDatabaseInTx đọc database cha trong transaction.
+// Database không tồn tại hoặc đã bị xóa mềm đều trả ErrDatabaseNotFound.
+func getWoodDatabaseInTx(tx *firestore.Transaction, dbRef *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	snap, err := tx.Get(dbRef)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrDatabaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isDeleted(snap) {
+		return nil, ErrDatabaseNotFound
+	}
+	return snap, nil
+}
+
+// prepareNewWoodDatabase điền các field do server quản lý cho database sắp tạo. UpdatedAt được đưa về 0
+// để tag serverTimestamp ghi thời điểm commit thay vì giá trị client gửi lên.
+func prepareNewWoodDatabase(db *models.WoodDatabase, createdBy string, createdAt time.Time) {
+	db.Size = 0
+	db.Image, db.CoverPieceID = "", ""
+	db.CreatedAt, db.CreatedBy = createdAt, createdBy
+	db.UpdatedAt = time.Time{}
+	db.DeletedAt, db.DeletedBy = nil, ""
+}
+
+// mergeCurrentWoodDatabase giữ các field do server quản lý của current khi ghi đè bằng db
+func mergeCurrentWoodDatabase(db, current *models.WoodDatabase) {
+	db.Size = current.Size
+	db.Image, db.CoverPieceID = current.Image, current.CoverPieceID
+	db.CreatedAt, db.CreatedBy = current.CreatedAt, current.CreatedBy
+	db.UpdatedAt = time.Time{}
+	db.DeletedAt, db.DeletedBy = nil, ""
+}
+
+// CreateWoodDatabase tạo database mới với size = 0, trả ErrDatabaseExists nếu ID đã được dùng
+// (kể cả database đang nằm trong thùng rác). Các field do server quản lý được điền lại vào db.
+func CreateWoodDatabase(db *models.WoodDatabase, createdBy string) (time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	prepareNewWoodDatabase(db, createdBy, time.Now().UTC().Truncate(time.Microsecond))
+
+	wr, err := client.Collection(woodDatabaseCollection).Doc(db.ID).Create(ctx, db)
+	if status.Code(err) == codes.AlreadyExists {
+		return time.Time{}, ErrDatabaseExists
+	}
+	if err != nil {
+		return time.Time{}, mapError(err)
+	}
+
+	db.UpdatedAt = wr.UpdateTime
+	return db.UpdatedAt, nil
+}
+
+// UpdateWoodDatabase ghi đè database đang hoạt động, trả ErrDatabaseNotFound nếu không tồn tại hoặc đã bị xóa.
+// Size và ảnh bìa do server quản lý nên giá trị client gửi lên bị bỏ qua và giữ nguyên giá trị hiện tại.
+// ifMatch khác rỗng thì chỉ ghi khi update time hiện tại trùng khớp, ngược lại trả ErrPreconditionFailed.
+// Trả về update time mới của document.
+func UpdateWoodDatabase(db *models.WoodDatabase, ifMatch time.Time) (time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(db.ID)
+
+	var commit firestore.CommitResponse
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := getWoodDatabaseInTx(tx, dbRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+		var current models.WoodDatabase
+		if err := snap.DataTo(&current); err != nil {
+			return err
+		}
+		mergeCurrentWoodDatabase(db, &current)
+		return tx.Set(dbRef, db)
+	}, firestore.WithCommitResponseTo(&commit))
+	if err != nil {
+		return time.Time{}, mapPreconditionError(err)
+	}
+
+	db.UpdatedAt = commit.CommitTime()
+	return db.UpdatedAt, nil
+}
+
+// PatchWoodDatabase cập nhật một phần các field của database đang hoạt động và trả về bản sau khi cập nhật.
+// fields là map field path -> giá trị, giá trị nil nghĩa là xóa field.
+// ifMatch có ý nghĩa như ở UpdateWoodDatabase.
+func PatchWoodDatabase(databaseID string, fields map[string]interface{}, ifMatch time.Time) (*models.WoodDatabase, time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(databaseID)
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := getWoodDatabaseInTx(tx, dbRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+		return tx.Update(dbRef, fieldUpdates(fields), updatedAt(snap))
+	})
+	if err != nil {
+		return nil, time.Time{}, mapPreconditionError(err)
+	}
+
+	snap, err := dbRef.Get(ctx)
+	if err != nil {
+		return nil, time.Time{}, mapError(err)
+	}
+	var db models.WoodDatabase
+	if err := snap.DataTo(&db); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &db, snap.UpdateTime, nil
+}
+
+// SetWoodDatabaseCover chọn ảnh url của piece pieceID làm ảnh bìa của database, url rỗng thì lấy ảnh đại diện
+// của piece. pieceID rỗng để bỏ ảnh bìa. Piece phải đang hoạt động và thuộc database, ngược lại trả ErrPieceNotFound;
+// trả ErrImageNotInPiece nếu piece không có ảnh này (hoặc không có ảnh nào). ifMatch có ý nghĩa như ở UpdateWoodDatabase.
+func SetWoodDatabaseCover(databaseID, pieceID, url string, ifMatch time.Time) (*models.WoodDatabase, time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(databaseID)
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := getWoodDatabaseInTx(tx, dbRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+		if pieceID == "" {
+			return tx.Update(dbRef, fieldUpdates(map[string]interface{}{"image": "", "cover_piece_id": nil}), updatedAt(snap))
+		}
+
+		pieceSnap, err := getWoodPieceInTx(tx, client.Collection(woodPieceCollection).Doc(pieceID))
+		if err != nil {
+			return err
+		}
+		var piece models.WoodPiece
+		if err := pieceSnap.DataTo(&piece); err != nil {
+			return err
+		}
+		if piece.DatabaseID != databaseID {
+			return ErrPieceNotFound
+		}
+		if url == "" {
+			url = piece.Cover
+		}
+		if url == "" && len(piece.ImageUrls) > 0 {
+			url = piece.ImageUrls[0]
+		}
+		if url == "" || !slices.Contains(piece.ImageUrls, url) {
+			return ErrImageNotInPiece
+		}
+		return tx.Update(dbRef, fieldUpdates(map[string]interface{}{"image": url, "cover_piece_id": pieceID}), updatedAt(snap))
+	})
+	if err != nil {
+		return nil, time.Time{}, mapPreconditionError(err)
+	}
+
+	snap, err := dbRef.Get(ctx)
+	if err != nil {
+		return nil, time.Time{}, mapError(err)
+	}
+	var db models.WoodDatabase
+	if err := snap.DataTo(&db); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &db, snap.UpdateTime, nil
+}
+
+// RecomputeWoodDatabaseSize đếm lại số piece đang hoạt động của database và ghi vào size nếu bị lệch
+func RecomputeWoodDatabaseSize(databaseID string) (oldSize, newSize int, err error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(databaseID)
+	query := client.Collection(woodPieceCollection).Where("database_id", "==", databaseID)
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(dbRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrDatabaseNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var db models.WoodDatabase
+		if err := snap.DataTo(&db); err != nil {
+			return err
+		}
+
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+		count := 0
+		for _, doc := range docs {
+			if !isDeleted(doc) {
+				count++
+			}
+		}
+
+		oldSize, newSize = db.Size, count
+		if oldSize == newSize {
+			return nil
+		}
+		return tx.Update(dbRef, []firestore.Update{
+			{Path: "size", Value: count},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	return oldSize, newSize, mapError(err)
+}
+
+// DeleteWoodDatabase xóa mềm database. Khi cascade = false trả ErrDatabaseHasPieces nếu
+// database còn piece đang hoạt động; khi cascade = true các piece bị xóa mềm cùng thời điểm
+// với database để RestoreWoodDatabase có thể khôi phục lại đúng nhóm đó.
+// ifMatch có ý nghĩa như ở UpdateWoodDatabase.
+func DeleteWoodDatabase(databaseID, deletedBy string, cascade bool, ifMatch time.Time) (deletedPieces int, err error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(databaseID)
+	query := client.Collection(woodPieceCollection).Where("database_id", "==", databaseID)
+	deletedAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	var pieceRefs []*firestore.DocumentRef
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		pieceRefs = nil
+		snap, err := getWoodDatabaseInTx(tx, dbRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if !isDeleted(doc) {
+				pieceRefs = append(pieceRefs, doc.Ref)
+			}
+		}
+		if len(pieceRefs) > 0 && !cascade {
+			return ErrDatabaseHasPieces
+		}
+
+		return tx.Update(dbRef, softDeleteUpdates(deletedAt, deletedBy), updatedAt(snap))
+	})
+	if err != nil {
+		return 0, mapPreconditionError(err)
+	}
+
+	// Database đã bị xóa nên không thể tạo hay sửa piece của nó trong lúc cập nhật hàng loạt
+	if err := bulkUpdate(ctx, client, pieceRefs, softDeleteUpdates(deletedAt, deletedBy)); err != nil {
+		return 0, mapError(err)
+	}
+	return len(pieceRefs), nil
+}
+
+// RestoreWoodDatabase khôi phục database khỏi thùng rác cùng các piece bị xóa theo nó
+func RestoreWoodDatabase(databaseID string) (restoredPieces int, err error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(databaseID)
+	query := client.Collection(woodPieceCollection).Where("database_id", "==", databaseID)
+
+	var pieceRefs []*firestore.DocumentRef
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		pieceRefs = nil
+		snap, err := tx.Get(dbRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrDatabaseNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !isDeleted(snap) {
+			return ErrNotInTrash
+		}
+		var db models.WoodDatabase
+		if err := snap.DataTo(&db); err != nil {
+			return err
+		}
+
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			var p models.WoodPiece
+			if err := doc.DataTo(&p); err != nil {
+				return err
+			}
+			if p.DeletedAt != nil && p.DeletedAt.Equal(*db.DeletedAt) {
+				pieceRefs = append(pieceRefs, doc.Ref)
+			}
+		}
+
+		return tx.Update(dbRef, restoreUpdates())
+	})
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	if err := bulkUpdate(ctx, client, pieceRefs, restoreUpdates()); err != nil {
+		return 0, mapError(err)
+	}
+	return len(pieceRefs), nil
+}
+
+// PurgeWoodDatabase xóa vĩnh viễn database đang nằm trong thùng rác cùng toàn bộ piece của nó
+// (để lại tombstone cho delta sync), trả về các piece đã xóa. Trả ErrNotInTrash nếu database đã được khôi phục trước khi purge.
+// Counter ID piece được giữ lại để database tạo lại với cùng ID không cấp lại ID đã có tombstone.
+func PurgeWoodDatabase(databaseID string) ([]models.WoodPiece, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(databaseID)
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(dbRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrDatabaseNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !isDeleted(snap) {
+			return ErrNotInTrash
+		}
+		if err := tx.Set(tombstoneRef(client, dbRef), newTombstone(dbRef)); err != nil {
+			return err
+		}
+		return tx.Delete(dbRef)
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	iter := client.Collection(woodPieceCollection).Where("database_id", "==", databaseID).Documents(ctx)
+	defer iter.Stop()
+
+	var pieces []models.WoodPiece
+	var refs []*firestore.DocumentRef
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, mapError(err)
+		}
+		var p models.WoodPiece
+		if err := doc.DataTo(&p); err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, p)
+		refs = append(refs, doc.Ref)
+	}
+
+	if err := bulkTombstone(ctx, client, refs); err != nil {
+		return nil, mapError(err)
+	}
+	if err := bulkDelete(ctx, client, refs); err != nil {
+		return nil, mapError(err)
+	}
+	return pieces, nil
+}
+
+// ListDeleted lấy các document trong thùng rác bị xóa trước thời điểm before
+// (before rỗng nghĩa là lấy tất cả), sắp xếp theo thời điểm xóa
+func ListDeleted[T any](collection string, before time.Time) ([]T, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	coll := client.Collection(collection)
+	query := coll.Where("deleted_at", "!=", nil)
+	if !before.IsZero() {
+		query = coll.Where("deleted_at", "<", before)
+	}
+	return queryAll[T](ctx, query.OrderBy("deleted_at", firestore.Asc))
+}
+
+// fieldUpdates chuyển map field -> giá trị thành danh sách Update kèm updated_at
+func fieldUpdates(fields map[string]interface{}) []firestore.Update {
+	return append(setUpdates(fields), touch())
+}
+
+// setUpdates chuyển map field -> giá trị thành danh sách Update, giá trị nil thành firestore.Delete
+func setUpdates(fields map[string]interface{}) []firestore.Update {
+	updates := make([]firestore.Update, 0, len(fields)+1)
+	for path, value := range fields {
+		if value == nil {
+			value = firestore.Delete
+		}
+		updates = append(updates, firestore.Update{Path: path, Value: value})
+	}
+	return updates
+}
+
+// touch cập nhật updated_at theo giờ server, mọi thao tác ghi đều phải kèm theo
+func touch() firestore.Update {
+	return firestore.Update{Path: "updated_at", Value: firestore.ServerTimestamp}
+}
+
+// checkIfMatch so update time đã đọc trong transaction với phiên bản client gửi lên
+func checkIfMatch(snap *firestore.DocumentSnapshot, ifMatch time.Time) error {
+	if !ifMatch.IsZero() && !snap.UpdateTime.Equal(ifMatch) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// updatedAt tạo precondition để Firestore chỉ ghi khi document chưa bị sửa kể từ lần đọc snap
+func updatedAt(snap *firestore.DocumentSnapshot) firestore.Precondition {
+	return firestore.LastUpdateTime(snap.UpdateTime)
+}
+
+// mapPreconditionError dùng cho thao tác ghi có precondition updatedAt:
+// lỗi FailedPrecondition ở đây nghĩa là document đã bị sửa nên trả ErrPreconditionFailed
+func mapPreconditionError(err error) error {
+	if status.Code(err) == codes.FailedPrecondition {
+		return ErrPreconditionFailed
+	}
+	return mapError(err)
+}
+
+// sizeDelta tăng/giảm size của database một lượng delta (nguyên tử phía Firestore)
+func sizeDelta(delta int) []firestore.Update {
+	return []firestore.Update{{Path: "size", Value: firestore.Increment(delta)}, touch()}
+}
+
+func softDeleteUpdates(deletedAt time.Time, deletedBy string) []firestore.Update {
+	return []firestore.Update{
+		{Path: "deleted_at", Value: deletedAt},
+		{Path: "deleted_by", Value: deletedBy},
+		touch(),
+	}
+}
+
+func restoreUpdates() []firestore.Update {
+	return []firestore.Update{
+		{Path: "deleted_at", Value: firestore.Delete},
+		{Path: "deleted_by", Value: firestore.Delete},
+		touch(),
+	}
+}
+
+// bulkDelete xóa danh sách document bằng BulkWriter (tự gom thành các batch ghi)
+func bulkDelete(ctx context.Context, client *firestore.Client, refs []*firestore.DocumentRef) error {
+	return bulkWrite(ctx, client, refs, func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+		return bw.Delete(ref)
+	})
+}
+
+// bulkUpdate áp cùng một danh sách field update cho nhiều document
+func bulkUpdate(ctx context.Context, client *firestore.Client, refs []*firestore.DocumentRef, updates []firestore.Update) error {
+	return bulkWrite(ctx, client, refs, func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+		return bw.Update(ref, updates)
+	})
+}
+
+func bulkWrite(ctx context.Context, client *firestore.Client, refs []*firestore.DocumentRef, write func(*firestore.BulkWriter, *firestore.DocumentRef) (*firestore.BulkWriterJob, error)) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	bw := client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(refs))
+	for _, ref := range refs {
+		job, err := write(bw, ref)
+		if err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,595 @@
+package firestore
+
+import (
+	"backend/models"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	woodPieceCollection    = "wood_piece"
+	pieceCounterCollection = "wood_piece_counter"
+)
+
+var (
+	ErrPieceNotFound = newError(ErrNotFound, "wood piece not found")
+	ErrTooManyImages = newError(ErrConflict, "wood piece would exceed the image limit")
+	// ErrImageNotInPiece trả về khi URL ảnh không nằm trong image_urls của piece
+	ErrImageNotInPiece = newError(ErrNotFound, "image does not belong to the wood piece")
+	// ErrImageOrderMismatch trả về khi thứ tự mới không chứa đúng các ảnh hiện có của piece
+	ErrImageOrderMismatch = newError(ErrConflict, "image order does not match the images of the wood piece")
+)
+
+// pieceCounter là document đếm số thứ tự piece cho từng database
+type pieceCounter struct {
+	LastIndex int `firestore:"last_index"`
+}
+
+// formatPieceID sinh ID dạng <db>_NN. %02d chỉ là độ rộng tối thiểu nên
+// từ piece thứ 100 trở đi ID sẽ là <db>_100, <db>_101...
+func formatPieceID(databaseID string, index int) string {
+	return fmt.Sprintf("%s_%02d", databaseID, index)
+}
+
+// parsePieceIndex lấy số thứ tự từ ID dạng <db>_NN, trả false nếu ID không đúng định dạng
+func parsePieceIndex(databaseID, pieceID string) (int, bool) {
+	suffix, ok := strings.CutPrefix(pieceID, databaseID+"_")
+	if !ok || suffix == "" {
+		return 0, false
+	}
+	index, err := strconv.Atoi(suffix)
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// prepareNewWoodPiece điền các field do server quản lý cho piece sắp tạo. UpdatedAt được đưa về 0
+// để tag serverTimestamp ghi thời điểm commit thay vì giá trị client gửi lên.
+func prepareNewWoodPiece(piece *models.WoodPiece, createdBy string, createdAt time.Time) {
+	piece.CreatedAt, piece.CreatedBy = createdAt, createdBy
+	piece.UpdatedAt = time.Time{}
+	piece.DeletedAt, piece.DeletedBy = nil, ""
+	piece.Images, piece.Cover = nil, ""
+	syncPieceImages(piece, nil)
+}
+
+// mergeCurrentWoodPiece giữ các field do server quản lý (created_*, thông tin ảnh, cover) của current khi ghi đè bằng piece
+func mergeCurrentWoodPiece(piece, current *models.WoodPiece) {
+	piece.CreatedAt, piece.CreatedBy = current.CreatedAt, current.CreatedBy
+	piece.UpdatedAt = time.Time{}
+	piece.DeletedAt, piece.DeletedBy = nil, ""
+	piece.Cover = current.Cover
+	syncPieceImages(piece, current.Images)
+}
+
+// CreateWoodPiece cấp ID mới cho piece và tạo document trong cùng một transaction.
+// Số thứ tự được lấy từ counter của database nên hai request đồng thời không thể
+// nhận cùng một ID; document được ghi bằng Create nên nếu trùng sẽ báo lỗi thay vì ghi đè.
+// Size của database cha được tăng trong cùng transaction.
+// Các field do server quản lý (created_*, updated_at) được điền lại vào piece sau khi ghi.
+// Trả ErrDatabaseNotFound nếu database cha không tồn tại.
+func CreateWoodPiece(piece *models.WoodPiece, createdBy string) (time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	dbRef := client.Collection(woodDatabaseCollection).Doc(piece.DatabaseID)
+	counterRef := client.Collection(pieceCounterCollection).Doc(piece.DatabaseID)
+	pieces := client.Collection(woodPieceCollection)
+
+	prepareNewWoodPiece(piece, createdBy, time.Now().UTC().Truncate(time.Microsecond))
+
+	var commit firestore.CommitResponse
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := getWoodDatabaseInTx(tx, dbRef); err != nil {
+			return err
+		}
+
+		lastIndex, err := readLastPieceIndex(tx, client, counterRef, piece.DatabaseID)
+		if err != nil {
+			return err
+		}
+
+		piece.ID = formatPieceID(piece.DatabaseID, lastIndex+1)
+
+		if err := tx.Create(pieces.Doc(piece.ID), piece); err != nil {
+			return err
+		}
+		if err := tx.Update(dbRef, sizeDelta(1)); err != nil {
+			return err
+		}
+		return tx.Set(counterRef, pieceCounter{LastIndex: lastIndex + 1})
+	}, firestore.WithCommitResponseTo(&commit))
+	if err != nil {
+		return time.Time{}, mapError(err)
+	}
+
+	piece.UpdatedAt = commit.CommitTime()
+	return piece.UpdatedAt, nil
+}
+
+// getWoodPieceInTx đọc piece trong transaction, piece đã bị xóa mềm được coi như không tồn tại
+func getWoodPieceInTx(tx *firestore.Transaction, pieceRef *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	snap, err := tx.Get(pieceRef)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrPieceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isDeleted(snap) {
+		return nil, ErrPieceNotFound
+	}
+	return snap, nil
+}
+
+// UpdateWoodPiece ghi đè piece đã tồn tại sau khi kiểm tra database cha trong cùng transaction.
+// Nếu database_id thay đổi (chuyển piece sang database khác) thì size của hai database được
+// cập nhật trong cùng transaction. Thông tin ảnh và cover hiện tại được giữ theo URL.
+// Trả ErrPieceNotFound hoặc ErrDatabaseNotFound nếu piece hoặc database không tồn tại,
+// ErrPreconditionFailed nếu ifMatch khác rỗng và không trùng update time hiện tại.
+// Trả về update time mới của piece.
+func UpdateWoodPiece(piece *models.WoodPiece, ifMatch time.Time) (time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	pieceRef := client.Collection(woodPieceCollection).Doc(piece.ID)
+	dbRef := client.Collection(woodDatabaseCollection).Doc(piece.DatabaseID)
+
+	var commit firestore.CommitResponse
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := getWoodPieceInTx(tx, pieceRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+		var current models.WoodPiece
+		if err := snap.DataTo(&current); err != nil {
+			return err
+		}
+		if _, err := getWoodDatabaseInTx(tx, dbRef); err != nil {
+			return err
+		}
+		mergeCurrentWoodPiece(piece, &current)
+
+		moved := current.DatabaseID != piece.DatabaseID
+		// Piece mồ côi (database cũ không còn) thì không có size nào để giảm
+		var oldDBRef *firestore.DocumentRef
+		if moved {
+			ref := client.Collection(woodDatabaseCollection).Doc(current.DatabaseID)
+			if _, err := tx.Get(ref); err == nil {
+				oldDBRef = ref
+			} else if status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+
+		if err := tx.Set(pieceRef, piece); err != nil {
+			return err
+		}
+		if !moved {
+			return nil
+		}
+		if oldDBRef != nil {
+			if err := tx.Update(oldDBRef, sizeDelta(-1)); err != nil {
+				return err
+			}
+		}
+		return tx.Update(dbRef, sizeDelta(1))
+	}, firestore.WithCommitResponseTo(&commit))
+	if err != nil {
+		return time.Time{}, mapPreconditionError(err)
+	}
+
+	piece.UpdatedAt = commit.CommitTime()
+	return piece.UpdatedAt, nil
+}
+
+// PatchWoodPiece cập nhật một phần các field của piece và trả về bản sau khi cập nhật.
+// fields là map field path -> giá trị, giá trị nil nghĩa là xóa field. Nếu database_id thay đổi
+// thì database mới được kiểm tra và size của hai database được cập nhật trong cùng transaction.
+// Đổi image_urls thì images và cover được đồng bộ lại như ở UpdateWoodPiece.
+// ifMatch có ý nghĩa như ở UpdateWoodPiece.
+func PatchWoodPiece(pieceID string, fields map[string]interface{}, ifMatch time.Time) (*models.WoodPiece, time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	pieceRef := client.Collection(woodPieceCollection).Doc(pieceID)
+	dbs := client.Collection(woodDatabaseCollection)
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := getWoodPieceInTx(tx, pieceRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+		var current models.WoodPiece
+		if err := snap.DataTo(&current); err != nil {
+			return err
+		}
+
+		if urls, ok := fields["image_urls"]; ok {
+			edited := models.WoodPiece{Cover: current.Cover}
+			edited.ImageUrls, _ = urls.([]string)
+			syncPieceImages(&edited, current.Images)
+			fields["image_urls"], fields["images"] = edited.ImageUrls, edited.Images
+			fields["cover"] = nil
+			if edited.Cover != "" {
+				fields["cover"] = edited.Cover
+			}
+		}
+
+		newDatabaseID, _ := fields["database_id"].(string)
+		moved := newDatabaseID != "" && newDatabaseID != current.DatabaseID
+
+		var oldDBRef *firestore.DocumentRef
+		if moved {
+			if _, err := getWoodDatabaseInTx(tx, dbs.Doc(newDatabaseID)); err != nil {
+				return err
+			}
+			ref := dbs.Doc(current.DatabaseID)
+			if _, err := tx.Get(ref); err == nil {
+				oldDBRef = ref
+			} else if status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+
+		if err := tx.Update(pieceRef, fieldUpdates(fields), updatedAt(snap)); err != nil {
+			return err
+		}
+		if !moved {
+			return nil
+		}
+		if oldDBRef != nil {
+			if err := tx.Update(oldDBRef, sizeDelta(-1)); err != nil {
+				return err
+			}
+		}
+		return tx.Update(dbs.Doc(newDatabaseID), sizeDelta(1))
+	})
+	if err != nil {
+		return nil, time.Time{}, mapPreconditionError(err)
+	}
+
+	snap, err := pieceRef.Get(ctx)
+	if err != nil {
+		return nil, time.Time{}, mapError(err)
+	}
+	var piece models.WoodPiece
+	if err := snap.DataTo(&piece); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &piece, snap.UpdateTime, nil
+}
+
+// AddWoodPieceImages thêm URL ảnh vào cuối image_urls của piece trong một transaction (URL đã có được bỏ qua)
+// và trả về piece sau khi cập nhật. Trả ErrPieceNotFound nếu piece không tồn tại hoặc đang trong thùng rác,
+// ErrTooManyImages nếu vượt models.MaxPieceImages.
+func AddWoodPieceImages(pieceID string, urls []string) (*models.WoodPiece, error) {
+	piece, _, err := editWoodPieceImages(pieceID, time.Time{}, func(piece *models.WoodPiece) error {
+		for _, url := range urls {
+			if !slices.Contains(piece.ImageUrls, url) {
+				piece.ImageUrls = append(piece.ImageUrls, url)
+			}
+		}
+		if len(piece.ImageUrls) > models.MaxPieceImages {
+			return ErrTooManyImages
+		}
+		return nil
+	})
+	return piece, err
+}
+
+// ReorderWoodPieceImages sắp xếp lại ảnh của piece theo urls, urls phải chứa đúng các ảnh hiện có
+// (mỗi ảnh một lần), ngược lại trả ErrImageOrderMismatch. ifMatch có ý nghĩa như ở UpdateWoodPiece.
+func ReorderWoodPieceImages(pieceID string, urls []string, ifMatch time.Time) (*models.WoodPiece, time.Time, error) {
+	return editWoodPieceImages(pieceID, ifMatch, func(piece *models.WoodPiece) error {
+		if len(urls) != len(piece.ImageUrls) {
+			return ErrImageOrderMismatch
+		}
+		for i, url := range urls {
+			if !slices.Contains(piece.ImageUrls, url) || slices.Contains(urls[:i], url) {
+				return ErrImageOrderMismatch
+			}
+		}
+		piece.ImageUrls = slices.Clone(urls)
+		return nil
+	})
+}
+
+// UpdateWoodPieceImage ghi đè caption, view và magnification của ảnh img.URL trong piece.
+// Trả ErrImageNotInPiece nếu piece không có ảnh này. ifMatch có ý nghĩa như ở UpdateWoodPiece.
+func UpdateWoodPieceImage(pieceID string, img models.PieceImage, ifMatch time.Time) (*models.WoodPiece, time.Time, error) {
+	return editWoodPieceImages(pieceID, ifMatch, func(piece *models.WoodPiece) error {
+		i := slices.IndexFunc(piece.Images, func(p models.PieceImage) bool { return p.URL == img.URL })
+		if i < 0 {
+			return ErrImageNotInPiece
+		}
+		piece.Images[i] = img
+		return nil
+	})
+}
+
+// SetWoodPieceCover chọn ảnh url làm ảnh đại diện của piece, url rỗng để quay về mặc định (ảnh đầu tiên).
+// Trả ErrImageNotInPiece nếu piece không có ảnh này. ifMatch có ý nghĩa như ở UpdateWoodPiece.
+func SetWoodPieceCover(pieceID, url string, ifMatch time.Time) (*models.WoodPiece, time.Time, error) {
+	return editWoodPieceImages(pieceID, ifMatch, func(piece *models.WoodPiece) error {
+		if url != "" && !slices.Contains(piece.ImageUrls, url) {
+			return ErrImageNotInPiece
+		}
+		piece.Cover = url
+		return nil
+	})
+}
+
+// editWoodPieceImages đọc piece đang hoạt động trong transaction, gọi edit để sửa ImageUrls/Images/Cover
+// rồi ghi lại ba field này sau khi đồng bộ. Lỗi của edit được trả nguyên vẹn.
+// Trả về piece và update time sau khi cập nhật.
+func editWoodPieceImages(pieceID string, ifMatch time.Time, edit func(piece *models.WoodPiece) error) (*models.WoodPiece, time.Time, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	pieceRef := client.Collection(woodPieceCollection).Doc(pieceID)
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := getWoodPieceInTx(tx, pieceRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+		var piece models.WoodPiece
+		if err := snap.DataTo(&piece); err != nil {
+			return err
+		}
+		// Piece tạo trước khi có images được bổ sung trước khi sửa
+		syncPieceImages(&piece, piece.Images)
+		if err := edit(&piece); err != nil {
+			return err
+		}
+		syncPieceImages(&piece, piece.Images)
+
+		var cover interface{}
+		if piece.Cover != "" {
+			cover = piece.Cover
+		}
+		return tx.Update(pieceRef, fieldUpdates(map[string]interface{}{
+			"image_urls": piece.ImageUrls,
+			"images":     piece.Images,
+			"cover":      cover,
+		}), updatedAt(snap))
+	})
+	if err != nil {
+		return nil, time.Time{}, mapPreconditionError(err)
+	}
+
+	snap, err := pieceRef.Get(ctx)
+	if err != nil {
+		return nil, time.Time{}, mapError(err)
+	}
+	var piece models.WoodPiece
+	if err := snap.DataTo(&piece); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &piece, snap.UpdateTime, nil
+}
+
+// syncPieceImages dựng lại Images theo đúng thứ tự ImageUrls (URL trùng bị bỏ), thông tin của từng ảnh
+// lấy từ prev theo URL; Cover bị bỏ nếu ảnh đó không còn trong piece
+func syncPieceImages(piece *models.WoodPiece, prev []models.PieceImage) {
+	info := make(map[string]models.PieceImage, len(prev))
+	for _, img := range prev {
+		info[img.URL] = img
+	}
+
+	urls := make([]string, 0, len(piece.ImageUrls))
+	images := make([]models.PieceImage, 0, len(piece.ImageUrls))
+	for _, url := range piece.ImageUrls {
+		if slices.Contains(urls, url) {
+			continue
+		}
+		img := info[url]
+		img.URL = url
+		urls = append(urls, url)
+		images = append(images, img)
+	}
+	piece.ImageUrls, piece.Images = urls, images
+	if !slices.Contains(urls, piece.Cover) {
+		piece.Cover = ""
+	}
+}
+
+// DeleteWoodPiece xóa mềm piece và giảm size của database cha trong cùng transaction.
+// Piece vẫn nằm trong thùng rác cho tới khi bị purge. ifMatch có ý nghĩa như ở UpdateWoodPiece.
+// Trả về ID database chứa piece.
+func DeleteWoodPiece(pieceID, deletedBy string, ifMatch time.Time) (databaseID string, err error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	pieceRef := client.Collection(woodPieceCollection).Doc(pieceID)
+	deletedAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := getWoodPieceInTx(tx, pieceRef)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(snap, ifMatch); err != nil {
+			return err
+		}
+		var piece models.WoodPiece
+		if err := snap.DataTo(&piece); err != nil {
+			return err
+		}
+		databaseID = piece.DatabaseID
+
+		dbRef := client.Collection(woodDatabaseCollection).Doc(piece.DatabaseID)
+		_, err = tx.Get(dbRef)
+		parentExists := err == nil
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if err := tx.Update(pieceRef, softDeleteUpdates(deletedAt, deletedBy), updatedAt(snap)); err != nil {
+			return err
+		}
+		if !parentExists {
+			return nil
+		}
+		return tx.Update(dbRef, sizeDelta(-1))
+	})
+	return databaseID, mapPreconditionError(err)
+}
+
+// RestoreWoodPiece khôi phục piece khỏi thùng rác và tăng lại size của database cha.
+// Trả ErrDatabaseNotFound nếu database cha vẫn đang bị xóa. Trả về ID database chứa piece.
+func RestoreWoodPiece(pieceID string) (databaseID string, err error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	pieceRef := client.Collection(woodPieceCollection).Doc(pieceID)
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(pieceRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrPieceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !isDeleted(snap) {
+			return ErrNotInTrash
+		}
+		var piece models.WoodPiece
+		if err := snap.DataTo(&piece); err != nil {
+			return err
+		}
+		databaseID = piece.DatabaseID
+
+		dbRef := client.Collection(woodDatabaseCollection).Doc(piece.DatabaseID)
+		if _, err := getWoodDatabaseInTx(tx, dbRef); err != nil {
+			return err
+		}
+		if err := tx.Update(pieceRef, restoreUpdates()); err != nil {
+			return err
+		}
+		return tx.Update(dbRef, sizeDelta(1))
+	})
+	return databaseID, mapError(err)
+}
+
+// PurgeWoodPiece xóa vĩnh viễn piece đang nằm trong thùng rác (để lại tombstone cho delta sync), trả về piece đã xóa.
+// Database đang lấy ảnh bìa từ piece này bị bỏ ảnh bìa trong cùng transaction.
+// Trả ErrNotInTrash nếu piece đã được khôi phục trước khi purge.
+func PurgeWoodPiece(pieceID string) (*models.WoodPiece, error) {
+	ctx := context.Background()
+	client := getClient(ctx)
+	defer client.Close()
+
+	pieceRef := client.Collection(woodPieceCollection).Doc(pieceID)
+
+	var piece models.WoodPiece
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(pieceRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrPieceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !isDeleted(snap) {
+			return ErrNotInTrash
+		}
+		if err := snap.DataTo(&piece); err != nil {
+			return err
+		}
+		covered, err := tx.Documents(client.Collection(woodDatabaseCollection).Where("cover_piece_id", "==", pieceID)).GetAll()
+		if err != nil {
+			return err
+		}
+
+		for _, db := range covered {
+			clearCover := fieldUpdates(map[string]interface{}{"image": "", "cover_piece_id": nil})
+			if err := tx.Update(db.Ref, clearCover, updatedAt(db)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Set(tombstoneRef(client, pieceRef), newTombstone(pieceRef)); err != nil {
+			return err
+		}
+		return tx.Delete(pieceRef)
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return &piece, nil
+}
+
+// readLastPieceIndex đọc counter trong transaction. Nếu database chưa có counter
+// (dữ liệu cũ) thì lấy số thứ tự lớn nhất trong các document có ID dạng <db>_NN, kể cả tombstone
+// của piece đã purge để không cấp lại ID client đã được báo xóa. Quét theo ID
+// chứ không theo database_id vì piece đã chuyển sang database khác vẫn giữ ID cũ.
+func readLastPieceIndex(tx *firestore.Transaction, client *firestore.Client, counterRef *firestore.DocumentRef, databaseID string) (int, error) {
+	snap, err := tx.Get(counterRef)
+	if err == nil {
+		var counter pieceCounter
+		if err := snap.DataTo(&counter); err != nil {
+			return 0, err
+		}
+		return counter.LastIndex, nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return 0, err
+	}
+
+	maxIndex := 0
+	for _, scan := range []struct {
+		coll   *firestore.CollectionRef
+		prefix string
+	}{
+		{client.Collection(woodPieceCollection), ""},
+		{client.Collection(tombstoneCollection), woodPieceCollection + ":"},
+	} {
+		prefix := scan.prefix + databaseID + "_"
+		iter := tx.Documents(scan.coll.
+			Where(firestore.DocumentID, ">=", scan.coll.Doc(prefix)).
+			Where(firestore.DocumentID, "<", scan.coll.Doc(prefix+"\uf8ff")))
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return 0, err
+			}
+			id := strings.TrimPrefix(doc.Ref.ID, scan.prefix)
+			if index, ok := parsePieceIndex(databaseID, id); ok && index > maxIndex {
+				maxIndex = index
+			}
+		}
+		iter.Stop()
+	}
+	return maxIndex, nil
+}
@@ -0,0 +1,105 @@
+package firestore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"backend/models"
+)
+
+// Body client gửi lên có các field do server quản lý, tất cả phải bị bỏ qua khi ghi
+const clientDatabaseBody = `{
+	"id": "oak", "title": "Oak", "size": 99, "image": "https://evil/a.jpg", "cover_piece_id": "oak_09",
+	"created_at": "2001-01-01T00:00:00Z", "updated_at": "2099-01-01T00:00:00Z", "created_by": "mallory",
+	"deleted_at": "2001-01-01T00:00:00Z", "deleted_by": "mallory"
+}`
+
+const clientPieceBody = `{
+	"id": "oak_01", "database_id": "oak", "name": "Oak 1", "image_urls": ["https://a/1.jpg", "https://a/2.jpg"],
+	"images": [{"url": "https://a/1.jpg", "caption": "forged"}], "cover": "https://a/2.jpg",
+	"created_at": "2001-01-01T00:00:00Z", "updated_at": "2001-01-01T00:00:00Z", "created_by": "mallory",
+	"deleted_at": "2001-01-01T00:00:00Z", "deleted_by": "mallory"
+}`
+
+func decodeBody[T any](t *testing.T, body string) *T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatal(err)
+	}
+	return &v
+}
+
+var (
+	testCreatedAt = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	testCurrentAt = time.Date(2023, 5, 6, 7, 0, 0, 0, time.UTC)
+)
+
+func TestPrepareNewWoodDatabaseDiscardsServerFields(t *testing.T) {
+	db := decodeBody[models.WoodDatabase](t, clientDatabaseBody)
+	prepareNewWoodDatabase(db, "alice", testCreatedAt)
+
+	if !db.UpdatedAt.IsZero() {
+		t.Errorf("UpdatedAt = %v, want zero (server timestamp)", db.UpdatedAt)
+	}
+	if db.Size != 0 || db.Image != "" || db.CoverPieceID != "" || !db.CreatedAt.Equal(testCreatedAt) || db.CreatedBy != "alice" || db.DeletedAt != nil || db.DeletedBy != "" {
+		t.Errorf("server fields not reset: %+v", db)
+	}
+}
+
+func TestMergeCurrentWoodDatabaseDiscardsServerFields(t *testing.T) {
+	db := decodeBody[models.WoodDatabase](t, clientDatabaseBody)
+	current := &models.WoodDatabase{
+		ID: "oak", Size: 3, Image: "https://a/cover.jpg", CoverPieceID: "oak_01",
+		CreatedAt: testCurrentAt, UpdatedAt: testCurrentAt, CreatedBy: "alice",
+	}
+	mergeCurrentWoodDatabase(db, current)
+
+	if !db.UpdatedAt.IsZero() {
+		t.Errorf("UpdatedAt = %v, want zero (server timestamp)", db.UpdatedAt)
+	}
+	if db.Size != 3 || db.Image != current.Image || db.CoverPieceID != "oak_01" ||
+		!db.CreatedAt.Equal(testCurrentAt) || db.CreatedBy != "alice" || db.DeletedAt != nil || db.DeletedBy != "" {
+		t.Errorf("server fields not kept from current: %+v", db)
+	}
+	if db.Title != "Oak" {
+		t.Errorf("Title = %q, client field lost", db.Title)
+	}
+}
+
+func TestPrepareNewWoodPieceDiscardsServerFields(t *testing.T) {
+	piece := decodeBody[models.WoodPiece](t, clientPieceBody)
+	prepareNewWoodPiece(piece, "alice", testCreatedAt)
+
+	if !piece.UpdatedAt.IsZero() {
+		t.Errorf("UpdatedAt = %v, want zero (server timestamp)", piece.UpdatedAt)
+	}
+	if !piece.CreatedAt.Equal(testCreatedAt) || piece.CreatedBy != "alice" || piece.DeletedAt != nil || piece.DeletedBy != "" {
+		t.Errorf("server fields not reset: %+v", piece)
+	}
+	if piece.Cover != "" || len(piece.Images) != 2 || piece.Images[0].Caption != "" {
+		t.Errorf("images/cover from client kept: cover %q, images %+v", piece.Cover, piece.Images)
+	}
+}
+
+func TestMergeCurrentWoodPieceDiscardsServerFields(t *testing.T) {
+	piece := decodeBody[models.WoodPiece](t, clientPieceBody)
+	current := &models.WoodPiece{
+		ID: "oak_01", DatabaseID: "oak", ImageUrls: []string{"https://a/1.jpg"},
+		Images:    []models.PieceImage{{URL: "https://a/1.jpg", Caption: "end grain"}},
+		Cover:     "https://a/1.jpg",
+		CreatedAt: testCurrentAt, UpdatedAt: testCurrentAt, CreatedBy: "alice",
+	}
+	mergeCurrentWoodPiece(piece, current)
+
+	if !piece.UpdatedAt.IsZero() {
+		t.Errorf("UpdatedAt = %v, want zero (server timestamp)", piece.UpdatedAt)
+	}
+	if !piece.CreatedAt.Equal(testCurrentAt) || piece.CreatedBy != "alice" || piece.DeletedAt != nil || piece.DeletedBy != "" {
+		t.Errorf("server fields not kept from current: %+v", piece)
+	}
+	if piece.Cover != "https://a/1.jpg" || len(piece.Images) != 2 || piece.Images[0].Caption != "end grain" {
+		t.Errorf("images/cover not kept from current: cover %q, images %+v", piece.Cover, piece.Images)
+	}
+}
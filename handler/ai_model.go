@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"backend/apperr"
 	"backend/service"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,7 +17,7 @@ import (
 func GetModelVersion(c *gin.Context) {
 	meta, err := service.GetCurrentModelMetadata()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, meta)
@@ -25,7 +27,7 @@ func GetModelVersion(c *gin.Context) {
 func ListModelVersions(c *gin.Context) {
 	versions, err := service.ListVersions()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, versions)
@@ -35,23 +37,47 @@ func ListModelVersions(c *gin.Context) {
 func ActivateNewModel(c *gin.Context) {
 	versionStr := c.Query("version")
 	if versionStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "version required"})
+		respondError(c, apperr.BadRequest("version required"))
 		return
 	}
 	version, _ := strconv.Atoi(versionStr)
 	err := service.ActivateVersion(version)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, apperr.BadRequest(err.Error()))
 		return
 	}
+	service.InvalidateOfflineBundle()
 	c.JSON(http.StatusOK, gin.H{"message": "version activated", "version": version})
 }
 
+// PUT /model/labels?version=N
+// Body là mảng JSON nhãn theo thứ tự class index của model
+func SetModelLabels(c *gin.Context) {
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil {
+		respondError(c, apperr.BadRequest("version required"))
+		return
+	}
+
+	var labels []string
+	if err := decodeJSON(c, &labels); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if err := service.SetVersionLabels(version, labels); err != nil {
+		respondError(c, apperr.NotFound(err.Error()))
+		return
+	}
+	service.InvalidateOfflineBundle()
+	c.JSON(http.StatusOK, gin.H{"message": "labels updated", "version": version, "labels": labels})
+}
+
 // POST /model/upload
 func UploadNewModel(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file not provided"})
+		respondError(c, apperr.BadRequest("file not provided"))
 		return
 	}
 
@@ -60,6 +86,15 @@ func UploadNewModel(c *gin.Context) {
 		name = file.Filename
 	}
 
+	// labels (tùy chọn): mảng JSON, phần tử thứ i là nhãn của class i
+	var labels []string
+	if raw := c.PostForm("labels"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+			respondError(c, apperr.BadRequest("labels must be a JSON array of strings"))
+			return
+		}
+	}
+
 	log.Println(file.Filename)
 
 	vInfo, err := service.ReadVersion()
@@ -81,14 +116,14 @@ func UploadNewModel(c *gin.Context) {
 
 	dst := filepath.Join("models", fmt.Sprintf("model_v%d_%s", newVersion, file.Filename))
 	if err := c.SaveUploadedFile(file, dst); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot save file"})
+		respondError(c, apperr.Internal("cannot save file"))
 		return
 	}
 
-	// Upload lên Cloudinary và lưu URL
-	entry, err := service.UploadNewModel(dst, newVersion, name)
+	// Upload lên blob store và lưu URL
+	entry, err := service.UploadNewModel(dst, newVersion, name, labels)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
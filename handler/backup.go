@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"backend/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportLibrary tải về bản backup toàn bộ thư viện dạng ZIP (images=true để kèm file ảnh).
+// Khôi phục bằng `librarytool restore`.
+func ExportLibrary(c *gin.Context) {
+	includeImages := c.DefaultQuery("images", "false") == "true"
+
+	filename := fmt.Sprintf("wood-library-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// Archive được ghi thẳng vào response; nếu lỗi khi đã ghi một phần thì chỉ có thể ghi log
+	if _, err := service.ExportLibrary(c.Writer, includeImages); err != nil {
+		respondError(c, err)
+		return
+	}
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"backend/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOfflineBundle trả về offline bundle của thư viện. ETag là hash nội dung nên app gửi lại
+// If-None-Match và chỉ tải bundle khi dữ liệu đã thay đổi (ngược lại nhận 304).
+// embed_thumbnails=true nhúng ảnh thu nhỏ vào bundle dạng data URI.
+func GetOfflineBundle(c *gin.Context) {
+	embed := c.DefaultQuery("embed_thumbnails", "false") == "true"
+
+	blob, err := service.GetOfflineBundle(embed)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("ETag", blob.ETag)
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Vary", "Accept-Encoding")
+	if etagMatches(c.GetHeader("If-None-Match"), blob.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		c.Header("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, "application/json; charset=utf-8", blob.Gzipped)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", blob.JSON)
+}
+
+// etagMatches kiểm tra header If-None-Match (có thể chứa nhiều ETag, hoặc weak ETag) có khớp etag không
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/apperr"
+	"backend/firestore"
+	"backend/service"
+	"backend/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signUploadRequest là body của SignUpload
+type signUploadRequest struct {
+	ContentType string `json:"content_type" binding:"required"`
+	Size        int64  `json:"size" binding:"required,gt=0"`
+}
+
+// confirmUploadRequest là body của ConfirmUpload
+type confirmUploadRequest struct {
+	UploadID       string `json:"upload_id" binding:"required"`
+	PieceID        string `json:"piece_id"`
+	RecordLocation bool   `json:"record_location"`
+	AllowDuplicate bool   `json:"allow_duplicate"`
+}
+
+// SignUpload cấp tham số đã ký để client upload ảnh thẳng lên blob store (không đi qua server).
+// content_type phải là image/jpeg, image/png, image/webp hoặc image/heic, size (byte) không vượt IMAGE_MAX_MB.
+func SignUpload(c *gin.Context) {
+	var req signUploadRequest
+	if err := decodeJSON(c, &req); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := validation.Struct(req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	upload, err := service.CreateDirectUpload(c, req.ContentType, req.Size, c.GetString("uid"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, upload)
+}
+
+// ConfirmUpload xác nhận ảnh đã upload theo upload_id: ảnh được kiểm tra, xử lý như upload_image
+// và gắn vào piece_id nếu có. Trả 409 upload_incomplete nếu file chưa có trên blob store.
+func ConfirmUpload(c *gin.Context) {
+	var req confirmUploadRequest
+	if err := decodeJSON(c, &req); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := validation.Struct(req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	asset, piece, err := service.ConfirmDirectUpload(c, req.UploadID, req.PieceID, service.UploadOptions{
+		CreatedBy:      c.GetString("uid"),
+		RecordLocation: req.RecordLocation,
+		AllowDuplicate: req.AllowDuplicate,
+	})
+	if errors.Is(err, firestore.ErrPieceNotFound) {
+		respondError(c, apperr.Validation(apperr.FieldError{
+			Field: "piece_id", Code: "not_found", Message: "piece_id does not refer to an existing piece",
+		}))
+		return
+	}
+	if errors.Is(err, firestore.ErrTooManyImages) {
+		respondError(c, apperr.Conflict("Piece already has the maximum number of images"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	resp := gin.H{
+		"message": "Image uploaded successfully",
+		"url":     asset.URL,
+		"image":   asset,
+	}
+	if piece != nil {
+		service.PublishChange(service.ChangeUpdated, "wood_piece", piece.ID, piece.DatabaseID, piece)
+		resp["piece"] = piece
+	}
+	c.JSON(http.StatusOK, resp)
+}
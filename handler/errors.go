@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"backend/apperr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondError ghi lỗi vào context và dừng chain, middleware.ErrorHandler sẽ chọn status và trả response
+func respondError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// decodeJSON đọc body JSON vào obj mà chưa validate, để handler còn điền các field lấy từ URL
+func decodeJSON(c *gin.Context, obj interface{}) error {
+	if err := json.NewDecoder(c.Request.Body).Decode(obj); err != nil {
+		return apperr.BadRequest("Invalid JSON body: " + err.Error())
+	}
+	return nil
+}
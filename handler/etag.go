@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/apperr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag được sinh từ update time của document trên Firestore (đơn vị nano giây, dạng hex)
+func formatETag(updateTime time.Time) string {
+	return `"` + strconv.FormatInt(updateTime.UnixNano(), 16) + `"`
+}
+
+func setETag(c *gin.Context, updateTime time.Time) {
+	if !updateTime.IsZero() {
+		c.Header("ETag", formatETag(updateTime))
+	}
+}
+
+// ifMatch đọc header If-Match và trả về update time mà client mong đợi.
+// Không có header hoặc "*" trả về thời gian rỗng (không kiểm tra).
+// Header không phải ETag do server cấp (kể cả weak ETag) thì trả 412 và ok = false.
+func ifMatch(c *gin.Context) (updateTime time.Time, ok bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return time.Time{}, true
+	}
+
+	tag, found := strings.CutPrefix(header, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	if found && closed {
+		if nanos, err := strconv.ParseInt(tag, 16, 64); err == nil {
+			return time.Unix(0, nanos), true
+		}
+	}
+
+	respondPreconditionFailed(c)
+	return time.Time{}, false
+}
+
+func respondPreconditionFailed(c *gin.Context) {
+	respondError(c, apperr.PreconditionFailed("Resource was modified, reload it and try again"))
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamChanges đẩy các thay đổi của database và piece qua Server-Sent Events.
+// database_id lọc theo một database. Khi kết nối lại, client gửi header Last-Event-ID (hoặc query
+// last_event_id) để nhận các event đã lỡ; nếu không nối tiếp được, server gửi event "reset"
+// để client tải lại dữ liệu rồi tiếp tục nghe.
+func StreamChanges(c *gin.Context) {
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	sub, missed, complete := service.SubscribeChanges(c.Query("database_id"), lastEventID)
+	defer sub.Unsubscribe()
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // tắt buffer của nginx
+	c.Status(http.StatusOK)
+
+	// Thời gian chờ trước khi trình duyệt tự kết nối lại
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	if !complete {
+		fmt.Fprint(c.Writer, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range missed {
+		writeChangeEvent(c.Writer, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(config.ChangeFeedHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Bị ngắt vì đọc không kịp, client kết nối lại với Last-Event-ID để đọc tiếp
+				return
+			}
+			writeChangeEvent(c.Writer, event)
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeChangeEvent(w io.Writer, event service.ChangeEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportLibrary nhập hàng loạt database và piece từ file CSV, JSON Lines hoặc ZIP (file dữ liệu kèm ảnh).
+// Trả báo cáo theo từng dòng: 422 nếu có dòng không hợp lệ (không ghi gì), 200 với dry_run=true,
+// 201 khi ghi xong, 207 nếu một phần ghi lỗi.
+func ImportLibrary(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.ImportMaxBytes)
+	dryRun := c.DefaultQuery("dry_run", "false") == "true"
+
+	fileHeader, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, apperr.New(http.StatusRequestEntityTooLarge, "too_large", "Import file exceeds the size limit"))
+		return
+	}
+	if err != nil {
+		respondError(c, apperr.BadRequest("Missing file"))
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		respondError(c, apperr.Internal("Cannot open file"))
+		return
+	}
+	defer file.Close()
+
+	src, err := service.ParseImport(fileHeader.Filename, file, fileHeader.Size)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	report, err := service.Import(src, c.GetString("uid"), dryRun)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	status := http.StatusCreated
+	switch {
+	case report.Invalid > 0:
+		status = http.StatusUnprocessableEntity
+	case dryRun:
+		status = http.StatusOK
+	case report.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, report)
+}
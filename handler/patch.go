@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"backend/apperr"
+	"backend/validation"
+)
+
+// mergePatchFields đọc body theo JSON Merge Patch (RFC 7396) và trả về map field path -> giá trị
+// để ghi bằng Firestore Update. Giá trị null nghĩa là xóa field (trả về nil trong map).
+// Key phải trùng với tên field firestore của model; key lạ hoặc thuộc readOnly sẽ bị từ chối,
+// giá trị được kiểm tra theo rule `binding` của field tương ứng.
+func mergePatchFields(body []byte, model interface{}, readOnly ...string) (map[string]interface{}, error) {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		return nil, apperr.BadRequest("Patch body must be a JSON object")
+	}
+	if len(patch) == 0 {
+		return nil, apperr.BadRequest("Patch body has no fields")
+	}
+
+	fields := patchableFields(reflect.TypeOf(model))
+
+	var details []apperr.FieldError
+	updates := make(map[string]interface{}, len(patch))
+	for _, key := range slices.Sorted(maps.Keys(patch)) {
+		raw := patch[key]
+		field, ok := fields[key]
+		if !ok {
+			details = append(details, apperr.FieldError{Field: key, Code: "unknown_field", Message: key + " is not a known field"})
+			continue
+		}
+		if slices.Contains(readOnly, key) {
+			details = append(details, apperr.FieldError{Field: key, Code: "read_only", Message: key + " is managed by the server"})
+			continue
+		}
+
+		var value interface{}
+		if !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			v := reflect.New(field.Type)
+			if err := json.Unmarshal(raw, v.Interface()); err != nil {
+				details = append(details, apperr.FieldError{Field: key, Code: "invalid_type", Message: key + " must be of type " + field.Type.String()})
+				continue
+			}
+			value = v.Elem().Interface()
+		}
+
+		if err := validation.Field(model, field.Name, key, value); err != nil {
+			var appErr *apperr.Error
+			if !errors.As(err, &appErr) {
+				return nil, err
+			}
+			details = append(details, appErr.Details...)
+			continue
+		}
+		updates[key] = value
+	}
+
+	if len(details) > 0 {
+		return nil, apperr.Validation(details...)
+	}
+	return updates, nil
+}
+
+// patchableFields lấy các field của struct model theo tên field firestore
+func patchableFields(t reflect.Type) map[string]reflect.StructField {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+		if name == "" || name == "-" || !f.IsExported() {
+			continue
+		}
+		fields[name] = f
+	}
+	return fields
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/apperr"
+	"backend/firestore"
+	"backend/models"
+	"backend/service"
+	"backend/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errImageNotInPiece là lỗi trả về khi url không phải ảnh của piece
+var errImageNotInPiece = apperr.Validation(apperr.FieldError{
+	Field:   "url",
+	Code:    "not_found",
+	Message: "url is not one of the images of the piece",
+})
+
+// reorderPieceImagesRequest là body của ReorderPieceImages
+type reorderPieceImagesRequest struct {
+	ImageUrls []string `json:"image_urls" binding:"required,max=100"`
+}
+
+// pieceImageRequest là body của UpdatePieceImage
+type pieceImageRequest struct {
+	URL           string  `json:"url" binding:"required"`
+	Caption       string  `json:"caption" binding:"max=500"`
+	View          string  `json:"view" binding:"omitempty,oneof=end_grain flat_sawn quarter_sawn other"`
+	Magnification float64 `json:"magnification" binding:"gte=0,lte=10000"`
+}
+
+// pieceCoverRequest là body của SetPieceCover
+type pieceCoverRequest struct {
+	URL string `json:"url"`
+}
+
+// ReorderPieceImages sắp xếp lại ảnh của piece. image_urls phải chứa đúng các ảnh hiện có,
+// trả 409 nếu danh sách ảnh đã thay đổi (nên dùng kèm If-Match).
+func ReorderPieceImages(c *gin.Context) {
+	var req reorderPieceImagesRequest
+	if err := decodeJSON(c, &req); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := validation.Struct(req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	expected, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	piece, updateTime, err := firestore.ReorderWoodPieceImages(c.Param("id"), req.ImageUrls, expected)
+	respondPieceImages(c, piece, updateTime, err)
+}
+
+// UpdatePieceImage ghi đè caption, view (end_grain, flat_sawn, quarter_sawn, other) và magnification
+// của một ảnh trong piece; trường không gửi sẽ bị xóa.
+func UpdatePieceImage(c *gin.Context) {
+	var req pieceImageRequest
+	if err := decodeJSON(c, &req); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := validation.Struct(req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	expected, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	piece, updateTime, err := firestore.UpdateWoodPieceImage(c.Param("id"), models.PieceImage{
+		URL:           req.URL,
+		Caption:       req.Caption,
+		View:          req.View,
+		Magnification: req.Magnification,
+	}, expected)
+	respondPieceImages(c, piece, updateTime, err)
+}
+
+// SetPieceCover chọn ảnh đại diện của piece, url rỗng để dùng ảnh đầu tiên
+func SetPieceCover(c *gin.Context) {
+	var req pieceCoverRequest
+	if err := decodeJSON(c, &req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	expected, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	piece, updateTime, err := firestore.SetWoodPieceCover(c.Param("id"), req.URL, expected)
+	respondPieceImages(c, piece, updateTime, err)
+}
+
+// respondPieceImages trả kết quả chung của các API sửa ảnh của piece
+func respondPieceImages(c *gin.Context, piece *models.WoodPiece, updateTime time.Time, err error) {
+	if errors.Is(err, firestore.ErrImageNotInPiece) {
+		respondError(c, errImageNotInPiece)
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeUpdated, "wood_piece", piece.ID, piece.DatabaseID, piece)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Updated successfully",
+		"data":    piece,
+	})
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"backend/firestore"
+	"backend/models"
+	"backend/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Contract test: mọi response thành công chứa WoodDatabase/WoodPiece đều dùng cùng một bộ key (snake_case,
+// có created_at/updated_at/created_by). Response được dựng đúng như handler trả về rồi render qua gin,
+// không cần Firestore.
+
+var (
+	databaseKeys = []string{"id", "title", "size", "description", "image", "cover_piece_id", "created_at", "updated_at", "created_by"}
+	pieceKeys    = []string{"id", "database_id", "name", "description", "image_urls", "images", "cover", "created_at", "updated_at", "created_by"}
+	// Item trong thùng rác có thêm thông tin xóa
+	trashKeys = []string{"deleted_at", "deleted_by"}
+)
+
+var snakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func schemaDatabase() models.WoodDatabase {
+	at := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	return models.WoodDatabase{
+		ID: "oak", Title: "Oak", Size: 1, Description: "Quercus",
+		Image: "https://a/1.jpg", CoverPieceID: "oak_01",
+		CreatedAt: at, UpdatedAt: at.Add(time.Hour), CreatedBy: "alice",
+	}
+}
+
+func schemaPiece() models.WoodPiece {
+	at := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	return models.WoodPiece{
+		ID: "oak_01", DatabaseID: "oak", Name: "Oak 1", Description: "End grain",
+		ImageUrls: []string{"https://a/1.jpg"},
+		Images:    []models.PieceImage{{URL: "https://a/1.jpg", View: models.ImageViewEndGrain}},
+		Cover:     "https://a/1.jpg",
+		CreatedAt: at, UpdatedAt: at.Add(time.Hour), CreatedBy: "alice",
+	}
+}
+
+func deleted[T any](item T, mark func(*T, *time.Time, string)) T {
+	at := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	mark(&item, &at, "bob")
+	return item
+}
+
+// renderJSON render obj qua gin như c.JSON trong handler và decode lại thành JSON tổng quát
+func renderJSON(t *testing.T, obj any) map[string]any {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.JSON(http.StatusOK, obj)
+
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %v (%s)", err, w.Body.String())
+	}
+	return body
+}
+
+// item đi theo đường dẫn path (key của object, hoặc "0" cho phần tử đầu của mảng) trong body
+func item(t *testing.T, body any, path ...string) map[string]any {
+	t.Helper()
+	for _, p := range path {
+		switch v := body.(type) {
+		case map[string]any:
+			body = v[p]
+		case []any:
+			if len(v) == 0 {
+				t.Fatalf("empty array at %q", p)
+			}
+			body = v[0]
+		}
+	}
+	obj, ok := body.(map[string]any)
+	if !ok {
+		t.Fatalf("%v is not an object: %v", path, body)
+	}
+	return obj
+}
+
+func assertKeys(t *testing.T, obj map[string]any, want ...[]string) {
+	t.Helper()
+	expected := slices.Concat(want...)
+	got := slices.Sorted(maps.Keys(obj))
+	slices.Sort(expected)
+	if !slices.Equal(got, expected) {
+		t.Errorf("keys = %v\nwant %v", got, expected)
+	}
+	for _, k := range got {
+		if !snakeCase.MatchString(k) {
+			t.Errorf("key %q is not snake_case", k)
+		}
+	}
+}
+
+func TestWoodDatabaseSchema(t *testing.T) {
+	db := schemaDatabase()
+	trashed := deleted(db, func(d *models.WoodDatabase, at *time.Time, by string) { d.DeletedAt, d.DeletedBy = at, by })
+
+	cases := []struct {
+		name  string
+		body  any
+		path  []string
+		extra []string
+	}{
+		{name: "create", body: gin.H{"message": "Created successfully", "data": &db}, path: []string{"data"}},
+		{name: "get", body: &db},
+		{name: "list", body: firestore.Page[models.WoodDatabase]{Data: []models.WoodDatabase{db}, Total: 1}, path: []string{"data", "0"}},
+		{name: "put", body: gin.H{"message": "Updated successfully", "data": &db}, path: []string{"data"}},
+		{name: "patch", body: gin.H{"message": "Updated successfully", "data": &db}, path: []string{"data"}},
+		{name: "cover", body: gin.H{"message": "Updated successfully", "data": &db}, path: []string{"data"}},
+		{name: "sync", body: service.SyncResponse{Databases: service.SyncChanges[models.WoodDatabase]{Upserted: []models.WoodDatabase{db}}}, path: []string{"databases", "upserted", "0"}},
+		{name: "trash", body: gin.H{"databases": []models.WoodDatabase{trashed}, "pieces": []models.WoodPiece{}}, path: []string{"databases", "0"}, extra: trashKeys},
+		{name: "change event", body: service.ChangeEvent{Collection: "wood_database", Data: &db}, path: []string{"data"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertKeys(t, item(t, renderJSON(t, tc.body), tc.path...), databaseKeys, tc.extra)
+		})
+	}
+}
+
+func TestWoodPieceSchema(t *testing.T) {
+	piece := schemaPiece()
+	trashed := deleted(piece, func(p *models.WoodPiece, at *time.Time, by string) { p.DeletedAt, p.DeletedBy = at, by })
+
+	cases := []struct {
+		name  string
+		body  any
+		path  []string
+		extra []string
+	}{
+		{name: "create", body: gin.H{"message": "Created successfully", "data": &piece}, path: []string{"data"}},
+		{name: "get", body: &piece},
+		{name: "list", body: firestore.Page[models.WoodPiece]{Data: []models.WoodPiece{piece}, Total: 1}, path: []string{"data", "0"}},
+		{name: "put", body: gin.H{"message": "Updated successfully", "data": &piece}, path: []string{"data"}},
+		{name: "patch", body: gin.H{"message": "Updated successfully", "data": &piece}, path: []string{"data"}},
+		{name: "images", body: gin.H{"message": "Updated successfully", "data": &piece}, path: []string{"data"}},
+		{name: "sync", body: service.SyncResponse{Pieces: service.SyncChanges[models.WoodPiece]{Upserted: []models.WoodPiece{piece}}}, path: []string{"pieces", "upserted", "0"}},
+		{name: "trash", body: gin.H{"databases": []models.WoodDatabase{}, "pieces": []models.WoodPiece{trashed}}, path: []string{"pieces", "0"}, extra: trashKeys},
+		{name: "change event", body: service.ChangeEvent{Collection: "wood_piece", Data: &piece}, path: []string{"data"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertKeys(t, item(t, renderJSON(t, tc.body), tc.path...), pieceKeys, tc.extra)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/firestore"
+	"backend/service"
+	"backend/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// similarSearchRequest là body JSON của SearchSimilar khi tìm theo ảnh đã có trong thư viện
+type similarSearchRequest struct {
+	ImageURL   string `json:"image_url" binding:"required"`
+	Limit      int    `json:"limit" binding:"gte=0"`
+	DatabaseID string `json:"database_id"`
+}
+
+// SearchSimilar tìm các piece có ảnh trông giống ảnh truy vấn nhất, kèm điểm 0-1.
+// Gửi ảnh mới qua multipart field file (form field limit, database_id), hoặc JSON {image_url, limit, database_id}
+// với image_url là ảnh của thư viện (piece đang chứa ảnh đó bị bỏ khỏi kết quả).
+func SearchSimilar(c *gin.Context) {
+	var (
+		results []service.SimilarPiece
+		err     error
+	)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		results, err = searchSimilarFile(c)
+	} else {
+		results, err = searchSimilarURL(c)
+	}
+	if errors.Is(err, firestore.ErrNotFound) {
+		respondError(c, apperr.Validation(apperr.FieldError{
+			Field: "image_url", Code: "not_found", Message: "image_url is not an image of the library",
+		}))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":   len(results),
+		"results": results,
+	})
+}
+
+func searchSimilarFile(c *gin.Context) ([]service.SimilarPiece, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.ImageMaxBytes+multipartOverhead)
+
+	fileHeader, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, service.ErrImageTooLarge
+	}
+	if err != nil {
+		return nil, apperr.BadRequest("Missing file")
+	}
+	if fileHeader.Size > config.ImageMaxBytes {
+		return nil, service.ErrImageTooLarge
+	}
+
+	limit := 0
+	if v := c.PostForm("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return nil, apperr.BadRequest("limit must be a non-negative integer")
+		}
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, apperr.Internal("Cannot open file")
+	}
+	defer file.Close()
+
+	return service.SearchSimilarImage(file, service.SimilarQuery{Limit: limit, DatabaseID: c.PostForm("database_id")})
+}
+
+func searchSimilarURL(c *gin.Context) ([]service.SimilarPiece, error) {
+	var req similarSearchRequest
+	if err := decodeJSON(c, &req); err != nil {
+		return nil, err
+	}
+	if err := validation.Struct(req); err != nil {
+		return nil, err
+	}
+	return service.SearchSimilarToURL(req.ImageURL, service.SimilarQuery{Limit: req.Limit, DatabaseID: req.DatabaseID})
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"backend/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sync trả về các database và piece được tạo, sửa hoặc xóa kể từ since (token của lần sync trước,
+// thời điểm RFC 3339, hoặc bỏ trống để lấy toàn bộ). Gọi tiếp với next_token cho tới khi has_more = false.
+func Sync(c *gin.Context) {
+	token, err := service.ParseSyncSince(c.Query("since"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if limit <= 0 {
+		limit = 500
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	resp, err := service.Sync(token, limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/apperr"
+	"backend/firestore"
+	"backend/models"
+	"backend/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListTrash liệt kê các database và piece đang nằm trong thùng rác
+func ListTrash(c *gin.Context) {
+	dbs, err := firestore.ListDeleted[models.WoodDatabase]("wood_database", time.Time{})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	pieces, err := firestore.ListDeleted[models.WoodPiece]("wood_piece", time.Time{})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"databases": dbs,
+		"pieces":    pieces,
+	})
+}
+
+// RestoreWoodDatabase khôi phục database cùng các piece bị xóa theo nó
+func RestoreWoodDatabase(c *gin.Context) {
+	id := c.Param("id")
+
+	restoredPieces, err := firestore.RestoreWoodDatabase(id)
+	if errors.Is(err, firestore.ErrNotInTrash) {
+		respondError(c, apperr.Conflict("Collection is not in trash"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	service.PublishChange(service.ChangeRestored, "wood_database", id, id, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Restored successfully",
+		"restored_pieces": restoredPieces,
+	})
+}
+
+// RestoreWoodPiece khôi phục piece, database cha phải đang hoạt động
+func RestoreWoodPiece(c *gin.Context) {
+	id := c.Param("id")
+
+	databaseID, err := firestore.RestoreWoodPiece(id)
+	if errors.Is(err, firestore.ErrNotInTrash) {
+		respondError(c, apperr.Conflict("Piece is not in trash"))
+		return
+	}
+	if errors.Is(err, firestore.ErrDatabaseNotFound) {
+		respondError(c, apperr.Conflict("Collection of this piece is deleted, restore it first"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	service.PublishChange(service.ChangeRestored, "wood_piece", id, databaseID, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Restored successfully"})
+}
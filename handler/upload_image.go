@@ -1,37 +1,191 @@
 package handler
 
 import (
+	"backend/apperr"
 	"backend/config"
+	"backend/firestore"
+	"backend/service"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 
-	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 	"github.com/gin-gonic/gin"
 )
 
+// Phần dư cho header multipart ngoài dung lượng file
+const multipartOverhead = 1 << 20
+
+// UploadImage nhận ảnh ở field file. Ảnh được kiểm tra theo nội dung trước khi tải lên:
+// 413 nếu vượt IMAGE_MAX_MB hoặc giới hạn kích thước, 415 nếu không phải JPEG, PNG, WebP hoặc HEIC.
+// Ảnh được xoay theo EXIF và bỏ metadata (GPS, EXIF, XMP) trước khi lưu; record_location=true để lưu tọa độ đã làm tròn.
+// Ảnh gần trùng ảnh đã có được liệt kê trong image.duplicates, hoặc bị từ chối 409 duplicate_image nếu
+// IMAGE_DUPLICATE_POLICY=reject (gửi allow_duplicate=true để vẫn lưu).
+// Trả về url của ảnh gốc và image gồm URL, kích thước, dung lượng của từng bản (original, thumbnail, medium, training).
 func UploadImage(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.ImageMaxBytes+multipartOverhead)
+
 	fileHeader, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, service.ErrImageTooLarge)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+		respondError(c, apperr.BadRequest("Missing file"))
+		return
+	}
+	if fileHeader.Size > config.ImageMaxBytes {
+		respondError(c, service.ErrImageTooLarge)
 		return
 	}
 
 	file, err := fileHeader.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot open file"})
+		respondError(c, apperr.Internal("Cannot open file"))
 		return
 	}
 	defer file.Close()
 
-	resp, err := config.CLD.Upload.Upload(c, file, uploader.UploadParams{
-		Folder: "images/",
+	asset, err := service.UploadImage(c, file, service.UploadOptions{
+		CreatedBy:      c.GetString("uid"),
+		RecordLocation: c.PostForm("record_location") == "true",
+		AllowDuplicate: c.PostForm("allow_duplicate") == "true",
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Image uploaded successfully",
-		"url":     resp.SecureURL,
+		"url":     asset.URL,
+		"image":   asset,
+	})
+}
+
+// UploadPieceImages nhận nhiều ảnh ở field files (tối đa IMAGE_UPLOAD_MAX_FILES), lưu song song và thêm vào
+// cuối image_urls của piece trong một lần ghi. Trả kết quả từng file theo thứ tự gửi lên:
+// 200 nếu mọi file thành công, 207 nếu một phần lỗi, 422 nếu không file nào lưu được.
+func UploadPieceImages(c *gin.Context) {
+	limit := config.ImageMaxBytes*int64(config.ImageUploadMaxFiles) + multipartOverhead
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	form, err := c.MultipartForm()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, service.ErrImageTooLarge)
+		return
+	}
+	if err != nil || len(form.File["files"]) == 0 {
+		respondError(c, apperr.BadRequest("Missing files"))
+		return
+	}
+
+	headers := form.File["files"]
+	files := make([]service.PieceImageFile, len(headers))
+	for i, fh := range headers {
+		files[i] = service.PieceImageFile{
+			Name: fh.Filename,
+			Open: func() (io.ReadCloser, error) {
+				if fh.Size > config.ImageMaxBytes {
+					return nil, service.ErrImageTooLarge
+				}
+				return fh.Open()
+			},
+		}
+	}
+
+	report, err := service.UploadPieceImages(c, c.Param("id"), files, service.UploadOptions{
+		CreatedBy:      c.GetString("uid"),
+		RecordLocation: c.PostForm("record_location") == "true",
+		AllowDuplicate: c.PostForm("allow_duplicate") == "true",
+	})
+	if errors.Is(err, firestore.ErrTooManyImages) {
+		respondError(c, apperr.Conflict("Piece would exceed the maximum number of images"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if report.Piece != nil {
+		service.PublishChange(service.ChangeUpdated, "wood_piece", report.Piece.ID, report.Piece.DatabaseID, report.Piece)
+	}
+
+	status := http.StatusOK
+	switch {
+	case report.Uploaded == 0:
+		status = http.StatusUnprocessableEntity
+	case report.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, report)
+}
+
+// GetImage trả về các bản của ảnh theo URL ảnh gốc (giá trị trong image_urls)
+func GetImage(c *gin.Context) {
+	url := c.Query("url")
+	if url == "" {
+		respondError(c, apperr.BadRequest("url is required"))
+		return
+	}
+
+	asset, err := service.GetImageAssetByURL(url)
+	if errors.Is(err, firestore.ErrNotFound) {
+		respondError(c, apperr.NotFound("No image record for this url"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, asset)
+}
+
+// ListDuplicateImages trả các cụm ảnh gần trùng trong thư viện.
+// distance (0-64, mặc định IMAGE_DUPLICATE_DISTANCE) là số bit khác nhau tối đa giữa hai ảnh.
+func ListDuplicateImages(c *gin.Context) {
+	distance := config.ImageDuplicateDistance
+	if v := c.Query("distance"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 64 {
+			respondError(c, apperr.Validation(apperr.FieldError{
+				Field: "distance", Code: "range", Message: "distance must be an integer between 0 and 64",
+			}))
+			return
+		}
+		distance = n
+	}
+
+	clusters, err := service.DuplicateImageClusters(distance)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"distance": distance,
+		"count":    len(clusters),
+		"clusters": clusters,
 	})
 }
+
+// ListOrphanImages báo cáo (dry run) các ảnh không còn được dùng và thời điểm chúng sẽ bị GC xóa
+func ListOrphanImages(c *gin.Context) {
+	report, err := service.CollectOrphanImages(config.ImageGCGrace, true)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// CollectOrphanImages chạy GC ảnh ngay, xóa các ảnh không được dùng lâu hơn IMAGE_GC_GRACE
+func CollectOrphanImages(c *gin.Context) {
+	report, err := service.CollectOrphanImages(config.ImageGCGrace, false)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
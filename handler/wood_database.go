@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"backend/apperr"
 	"backend/firestore"
 	"backend/models"
+	"backend/service"
+	"backend/validation"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -13,30 +17,29 @@ import (
 // CreateWoodDatabase tạo mới WoodDatabase
 func CreateWoodDatabase(c *gin.Context) {
 	var db models.WoodDatabase
-	if err := c.BindJSON(&db); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := decodeJSON(c, &db); err != nil {
+		respondError(c, err)
 		return
 	}
-
-	// Validate ID
-	if db.ID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+	if err := validation.Struct(db); err != nil {
+		respondError(c, err)
 		return
 	}
 
-	// Kiểm tra ID đã tồn tại chưa
-	exists, err := firestore.DocumentExists("wood_database", db.ID)
-	if exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Collection with this ID already exists"})
+	// Size, ảnh bìa, created_* và updated_at do server quản lý
+	updateTime, err := firestore.CreateWoodDatabase(&db, c.GetString("uid"))
+	if errors.Is(err, firestore.ErrDatabaseExists) {
+		respondError(c, apperr.Conflict("Collection with this ID already exists"))
 		return
 	}
-
-	err = firestore.CreateDocument("wood_database", db.ID, db)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeCreated, "wood_database", db.ID, db.ID, db)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Created successfully",
 		"data":    db,
@@ -47,36 +50,126 @@ func CreateWoodDatabase(c *gin.Context) {
 func UpdateWoodDatabase(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		respondError(c, apperr.BadRequest("ID is required"))
 		return
 	}
 
 	var db models.WoodDatabase
-	if err := c.BindJSON(&db); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := decodeJSON(c, &db); err != nil {
+		respondError(c, err)
 		return
 	}
 
 	// Đảm bảo ID trong body khớp với URL
 	db.ID = id
 
-	// Kiểm tra document có tồn tại không
-	exists, err := firestore.DocumentExists("wood_database", id)
+	if err := validation.Struct(db); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	expected, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	updateTime, err := firestore.UpdateWoodDatabase(&db, expected)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeUpdated, "wood_database", db.ID, db.ID, db)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Updated successfully",
+		"data":    db,
+	})
+}
+
+// PatchWoodDatabase cập nhật một phần WoodDatabase theo JSON Merge Patch,
+// chỉ các field có trong body được ghi, field null sẽ bị xóa
+func PatchWoodDatabase(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, apperr.BadRequest("ID is required"))
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		respondError(c, apperr.BadRequest(err.Error()))
+		return
+	}
+
+	fields, err := mergePatchFields(body, models.WoodDatabase{}, models.WoodDatabaseReadOnlyFields...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+
+	expected, ok := ifMatch(c)
+	if !ok {
 		return
 	}
 
-	err = firestore.UpdateDocument("wood_database", id, db)
+	db, updateTime, err := firestore.PatchWoodDatabase(id, fields, expected)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeUpdated, "wood_database", id, id, db)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Updated successfully",
+		"data":    db,
+	})
+}
+
+// databaseCoverRequest là body của SetDatabaseCover
+type databaseCoverRequest struct {
+	PieceID string `json:"piece_id"`
+	URL     string `json:"url"`
+}
+
+// SetDatabaseCover chọn ảnh bìa của database từ ảnh của một piece trong database.
+// url rỗng thì dùng ảnh đại diện của piece, piece_id rỗng để bỏ ảnh bìa.
+func SetDatabaseCover(c *gin.Context) {
+	id := c.Param("id")
+
+	var req databaseCoverRequest
+	if err := decodeJSON(c, &req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	expected, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	db, updateTime, err := firestore.SetWoodDatabaseCover(id, req.PieceID, req.URL, expected)
+	if errors.Is(err, firestore.ErrPieceNotFound) {
+		respondError(c, apperr.Validation(apperr.FieldError{
+			Field: "piece_id", Code: "not_found", Message: "piece_id does not refer to an existing piece of this collection",
+		}))
+		return
+	}
+	if errors.Is(err, firestore.ErrImageNotInPiece) {
+		respondError(c, errImageNotInPiece)
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeUpdated, "wood_database", id, id, db)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Updated successfully",
 		"data":    db,
@@ -87,16 +180,20 @@ func UpdateWoodDatabase(c *gin.Context) {
 func GetWoodDatabase(c *gin.Context) {
 	id := c.Query("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		respondError(c, apperr.BadRequest("ID is required"))
 		return
 	}
 
-	data, err := firestore.GetDocument("wood_database", id)
+	db, updateTime, err := firestore.Get[models.WoodDatabase]("wood_database", id)
+	if errors.Is(err, firestore.ErrNotFound) || (err == nil && db.DeletedAt != nil) {
+		err = firestore.ErrDatabaseNotFound
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, data)
+	setETag(c, updateTime)
+	c.JSON(http.StatusOK, db)
 }
 
 // ListWoodDatabase List all wood_database với phân trang
@@ -107,6 +204,7 @@ func ListWoodDatabase(c *gin.Context) {
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 	orderBy := c.DefaultQuery("order_by", "title")
 	descending := c.DefaultQuery("desc", "false") == "true"
+	includeDeleted := c.DefaultQuery("include_deleted", "false") == "true"
 
 	// Validate limit
 	if limit <= 0 {
@@ -117,45 +215,51 @@ func ListWoodDatabase(c *gin.Context) {
 	}
 
 	params := firestore.PaginationParams{
-		Limit:      limit,
-		Offset:     offset,
-		OrderBy:    orderBy,
-		Descending: descending,
+		Limit:          limit,
+		Offset:         offset,
+		OrderBy:        orderBy,
+		Descending:     descending,
+		IncludeDeleted: includeDeleted,
 	}
 
-	result, err := firestore.GetCollectionPaginated("wood_database", params)
+	result, err := firestore.ListPage[models.WoodDatabase]("wood_database", params)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, result)
 }
 
-// DeleteWoodDatabase xóa WoodDatabase
+// DeleteWoodDatabase xóa mềm WoodDatabase (chuyển vào thùng rác).
+// Mặc định trả 409 nếu database còn piece; truyền cascade=true để xóa luôn các piece của nó.
 func DeleteWoodDatabase(c *gin.Context) {
 	id := c.Query("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		respondError(c, apperr.BadRequest("ID is required"))
 		return
 	}
+	cascade := c.DefaultQuery("cascade", "false") == "true"
 
-	// Kiểm tra document có tồn tại không
-	exists, err := firestore.DocumentExists("wood_database", id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	expected, ok := ifMatch(c)
+	if !ok {
 		return
 	}
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+
+	deletedPieces, err := firestore.DeleteWoodDatabase(id, c.GetString("uid"), cascade, expected)
+	if errors.Is(err, firestore.ErrDatabaseHasPieces) {
+		respondError(c, apperr.Conflict("Collection still has pieces, use cascade=true to delete them"))
 		return
 	}
-
-	err = firestore.DeleteDocument("wood_database", id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Deleted successfully"})
+	service.PublishChange(service.ChangeDeleted, "wood_database", id, id, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Deleted successfully",
+		"deleted_pieces": deletedPieces,
+	})
 }
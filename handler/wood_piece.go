@@ -1,53 +1,52 @@
 package handler
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"backend/apperr"
 	"backend/firestore"
 	"backend/models"
+	"backend/service"
+	"backend/validation"
 
 	"github.com/gin-gonic/gin"
 )
 
+// errParentNotFound là lỗi trả về khi database_id không trỏ tới database đang hoạt động
+var errParentNotFound = apperr.Validation(apperr.FieldError{
+	Field:   "database_id",
+	Code:    "not_found",
+	Message: "database_id does not refer to an existing collection",
+})
+
 // CreateWoodPiece
 func CreateWoodPiece(c *gin.Context) {
 	var piece models.WoodPiece
-	if err := c.BindJSON(&piece); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := decodeJSON(c, &piece); err != nil {
+		respondError(c, err)
 		return
 	}
-
-	if piece.DatabaseID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "database_id is required"})
+	if err := validation.Struct(piece); err != nil {
+		respondError(c, err)
 		return
 	}
 
-	pieces, err := firestore.GetDocumentsByField("wood_piece", "database_id", piece.DatabaseID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+	// ID được cấp trong transaction theo counter của database
+	updateTime, err := firestore.CreateWoodPiece(&piece, c.GetString("uid"))
+	if errors.Is(err, firestore.ErrDatabaseNotFound) {
+		respondError(c, errParentNotFound)
 		return
 	}
-
-	maxIndex := 0
-	for _, p := range pieces {
-		var index int
-		fmt.Sscanf(p.ID, piece.DatabaseID+"_%d", &index)
-		if index > maxIndex {
-			maxIndex = index
-		}
-	}
-
-	newIndex := maxIndex + 1
-	piece.ID = fmt.Sprintf("%s_%02d", piece.DatabaseID, newIndex)
-
-	err = firestore.CreateDocument("wood_piece", piece.ID, piece)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		respondError(c, err)
 		return
 	}
 
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeCreated, "wood_piece", piece.ID, piece.DatabaseID, piece)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Created successfully",
 		"data":    piece,
@@ -58,36 +57,87 @@ func CreateWoodPiece(c *gin.Context) {
 func UpdateWoodPiece(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		respondError(c, apperr.BadRequest("ID is required"))
 		return
 	}
 
 	var piece models.WoodPiece
-	if err := c.BindJSON(&piece); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := decodeJSON(c, &piece); err != nil {
+		respondError(c, err)
 		return
 	}
 
 	// Đảm bảo ID trong body khớp với URL
 	piece.ID = id
 
-	// Kiểm tra document có tồn tại không
-	exists, err := firestore.DocumentExists("wood_piece", id)
+	if err := validation.Struct(piece); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	expected, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	updateTime, err := firestore.UpdateWoodPiece(&piece, expected)
+	if errors.Is(err, firestore.ErrDatabaseNotFound) {
+		respondError(c, errParentNotFound)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Piece not found"})
+
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeUpdated, "wood_piece", piece.ID, piece.DatabaseID, piece)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Updated successfully",
+		"data":    piece,
+	})
+}
+
+// PatchWoodPiece cập nhật một phần WoodPiece theo JSON Merge Patch.
+// Đổi database_id sẽ chuyển piece sang database khác.
+func PatchWoodPiece(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, apperr.BadRequest("ID is required"))
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		respondError(c, apperr.BadRequest(err.Error()))
+		return
+	}
+
+	fields, err := mergePatchFields(body, models.WoodPiece{}, models.WoodPieceReadOnlyFields...)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
-	err = firestore.UpdateDocument("wood_piece", id, piece)
+	expected, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	piece, updateTime, err := firestore.PatchWoodPiece(id, fields, expected)
+	if errors.Is(err, firestore.ErrDatabaseNotFound) {
+		respondError(c, errParentNotFound)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	setETag(c, updateTime)
+	service.PublishChange(service.ChangeUpdated, "wood_piece", id, piece.DatabaseID, piece)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Updated successfully",
 		"data":    piece,
@@ -98,23 +148,27 @@ func UpdateWoodPiece(c *gin.Context) {
 func GetWoodPiece(c *gin.Context) {
 	id := c.Query("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		respondError(c, apperr.BadRequest("ID is required"))
 		return
 	}
 
-	data, err := firestore.GetDocument("wood_piece", id)
+	piece, updateTime, err := firestore.Get[models.WoodPiece]("wood_piece", id)
+	if errors.Is(err, firestore.ErrNotFound) || (err == nil && piece.DeletedAt != nil) {
+		err = firestore.ErrPieceNotFound
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Piece not found"})
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, data)
+	setETag(c, updateTime)
+	c.JSON(http.StatusOK, piece)
 }
 
 // ListWoodPiecesByDatabase List WoodPiece by DatabaseID với phân trang
 func ListWoodPiecesByDatabase(c *gin.Context) {
 	dbID := c.Query("database_id")
 	if dbID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "database_id is required"})
+		respondError(c, apperr.BadRequest("database_id is required"))
 		return
 	}
 
@@ -123,6 +177,7 @@ func ListWoodPiecesByDatabase(c *gin.Context) {
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 	orderBy := c.DefaultQuery("order_by", "name")
 	descending := c.DefaultQuery("desc", "false") == "true"
+	includeDeleted := c.DefaultQuery("include_deleted", "false") == "true"
 
 	// Validate limit
 	if limit <= 0 {
@@ -133,45 +188,42 @@ func ListWoodPiecesByDatabase(c *gin.Context) {
 	}
 
 	params := firestore.PaginationParams{
-		Limit:      limit,
-		Offset:     offset,
-		OrderBy:    orderBy,
-		Descending: descending,
+		Limit:          limit,
+		Offset:         offset,
+		OrderBy:        orderBy,
+		Descending:     descending,
+		IncludeDeleted: includeDeleted,
 	}
 
-	result, err := firestore.GetCollectionWithFilter("wood_piece", "database_id", dbID, params)
+	result, err := firestore.ListPageWhere[models.WoodPiece]("wood_piece", "database_id", dbID, params)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, result)
 }
 
-// DeleteWoodPiece Delete WoodPiece
+// DeleteWoodPiece xóa mềm WoodPiece (chuyển vào thùng rác)
 func DeleteWoodPiece(c *gin.Context) {
 	id := c.Query("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
+		respondError(c, apperr.BadRequest("ID is required"))
 		return
 	}
 
-	// Kiểm tra document có tồn tại không
-	exists, err := firestore.DocumentExists("wood_piece", id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Piece not found"})
+	expected, ok := ifMatch(c)
+	if !ok {
 		return
 	}
 
-	err = firestore.DeleteDocument("wood_piece", id)
+	databaseID, err := firestore.DeleteWoodPiece(id, c.GetString("uid"), expected)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	service.PublishChange(service.ChangeDeleted, "wood_piece", id, databaseID, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted successfully"})
 }
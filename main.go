@@ -3,6 +3,8 @@ package main
 import (
 	"backend/config"
 	"backend/router"
+	"backend/service"
+	"backend/storage"
 	"log"
 )
 
@@ -10,8 +12,10 @@ func main() {
 	log.Println("Starting server...")
 	config.InitFirebase()
 	log.Println("Firebase initialized")
-	config.InitCloudinary()
-	log.Println("Cloudinary initialized")
+	storage.Init()
+
+	service.StartTrashPurger(config.TrashRetention, config.TrashPurgeInterval)
+	service.StartImageGC(config.ImageGCGrace, config.ImageGCInterval)
 
 	r := router.SetupRouter()
 
@@ -2,9 +2,9 @@ package middleware
 
 import (
 	"context"
-	"net/http"
 	"strings"
 
+	"backend/apperr"
 	"backend/config"
 
 	"github.com/gin-gonic/gin"
@@ -14,7 +14,7 @@ func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			_ = c.Error(apperr.Unauthorized("Authorization header required"))
 			c.Abort()
 			return
 		}
@@ -22,7 +22,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Lấy token từ header "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+			_ = c.Error(apperr.Unauthorized("Invalid authorization header format"))
 			c.Abort()
 			return
 		}
@@ -33,14 +33,14 @@ func AuthMiddleware() gin.HandlerFunc {
 		ctx := context.Background()
 		client, err := config.FirebaseApp.Auth(ctx)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize auth client"})
+			_ = c.Error(apperr.Internal("Failed to initialize auth client"))
 			c.Abort()
 			return
 		}
 
 		token, err := client.VerifyIDToken(ctx, idToken)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			_ = c.Error(apperr.Unauthorized("Invalid or expired token"))
 			c.Abort()
 			return
 		}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"backend/apperr"
+	"backend/firestore"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorHandler là nơi duy nhất chuyển lỗi thành response. Handler chỉ cần gọi c.Error(err) rồi Abort,
+// middleware chọn HTTP status theo loại lỗi và trả body dạng {"error": {...}} kèm request ID.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 {
+			return
+		}
+		err := c.Errors.Last().Err
+		appErr := toAppError(err)
+		appErr.RequestID = c.GetString(RequestIDKey)
+
+		if appErr.Status >= http.StatusInternalServerError {
+			log.Printf("[ERROR] %s %s request_id=%s: %v", c.Request.Method, c.Request.URL.Path, appErr.RequestID, err)
+		}
+
+		// Handler đã tự ghi response (vd lỗi giữa chừng khi đang stream) thì không ghi thêm
+		if c.Writer.Written() {
+			return
+		}
+		if appErr.Status == http.StatusServiceUnavailable {
+			c.Header("Retry-After", "5")
+		}
+		c.JSON(appErr.Status, gin.H{"error": appErr})
+	}
+}
+
+// Recovery bắt panic của handler và chuyển thành lỗi 500 cho ErrorHandler, thay cho gin.Recovery
+// (vốn trả 500 không có body)
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		_ = c.Error(fmt.Errorf("panic: %v", recovered))
+		c.Abort()
+	})
+}
+
+// toAppError chọn lỗi trả cho client. Kết quả luôn là bản sao để có thể gắn request ID
+// mà không sửa vào các lỗi dùng chung. Lỗi không rõ loại không lộ chi tiết ra ngoài.
+func toAppError(err error) *apperr.Error {
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		copied := *appErr
+		return &copied
+	}
+
+	switch {
+	case errors.Is(err, firestore.ErrNotFound):
+		return apperr.NotFound(err.Error())
+	case errors.Is(err, firestore.ErrConflict):
+		return apperr.Conflict(err.Error())
+	case errors.Is(err, firestore.ErrPrecondition):
+		return apperr.PreconditionFailed(err.Error())
+	case errors.Is(err, firestore.ErrUnavailable):
+		return apperr.Unavailable(err.Error())
+	}
+	return apperr.Internal("Internal server error")
+}
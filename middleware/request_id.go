@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey là key lưu request ID trong gin.Context
+	RequestIDKey = "request_id"
+)
+
+// Request ID do client gửi lên chỉ được dùng lại nếu ngắn và không chứa ký tự lạ (tránh chèn log)
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gắn cho mỗi request một ID (lấy từ header X-Request-ID nếu hợp lệ, không thì sinh mới),
+// trả lại trong header response để client đối chiếu với log server
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// Tên các bản của một ảnh
+const (
+	VariantOriginal  = "original"
+	VariantThumbnail = "thumbnail" // cắt vuông 256px cho danh sách
+	VariantMedium    = "medium"    // cạnh dài tối đa 1024px để xem chi tiết
+	VariantTraining  = "training"  // 640x640 letterbox, đúng kích thước đầu vào của model
+)
+
+// ImageAsset là một ảnh đã upload cùng các bản dẫn xuất, lưu trong collection image_asset.
+// URL là URL của ảnh gốc, chính là giá trị nằm trong WoodPiece.ImageUrls / WoodDatabase.Image.
+type ImageAsset struct {
+	ID        string                  `json:"id" firestore:"id"`
+	URL       string                  `json:"url" firestore:"url"`
+	Variants  map[string]ImageVariant `json:"variants" firestore:"variants"`
+	CreatedAt time.Time               `json:"created_at" firestore:"created_at"`
+	CreatedBy string                  `json:"created_by" firestore:"created_by"`
+	// Tài nguyên đang dùng ảnh, dạng "wood_piece/<id>" hoặc "wood_database/<id>" (kể cả item trong thùng rác).
+	// Job GC cập nhật lại mỗi lần chạy.
+	Owners []string `json:"owners" firestore:"owners"`
+	// Thời điểm GC thấy ảnh không còn owner (ảnh mới upload tính từ lúc tạo); nil khi đang được dùng
+	UnreferencedSince *time.Time     `json:"unreferenced_since,omitempty" firestore:"unreferenced_since,omitempty"`
+	Metadata          *ImageMetadata `json:"metadata,omitempty" firestore:"metadata,omitempty"`
+	// Difference hash 64 bit (hex) của ảnh, dùng để phát hiện ảnh gần trùng. Rỗng với ảnh không giải mã được (HEIC).
+	PHash string `json:"phash,omitempty" firestore:"phash,omitempty"`
+	// Descriptor màu/texture của ảnh dùng cho tìm ảnh tương tự, rỗng với ảnh không giải mã được
+	Descriptor []float64 `json:"-" firestore:"descriptor,omitempty"`
+
+	// Các ảnh đã có gần trùng ảnh này, chỉ trả về trong response upload
+	Duplicates []ImageDuplicate `json:"duplicates,omitempty" firestore:"-"`
+}
+
+// ImageDuplicate là một ảnh đã có gần trùng ảnh vừa upload
+type ImageDuplicate struct {
+	ImageID  string `json:"image_id"`
+	URL      string `json:"url"`
+	Distance int    `json:"distance"` // số bit khác nhau giữa hai phash
+}
+
+// ImageMetadata là thông tin chụp đọc từ EXIF trước khi metadata bị bỏ khỏi file
+type ImageMetadata struct {
+	CapturedAt  *time.Time     `json:"captured_at,omitempty" firestore:"captured_at,omitempty"`
+	CameraMake  string         `json:"camera_make,omitempty" firestore:"camera_make,omitempty"`
+	CameraModel string         `json:"camera_model,omitempty" firestore:"camera_model,omitempty"`
+	Location    *ImageLocation `json:"location,omitempty" firestore:"location,omitempty"`
+}
+
+// ImageLocation là tọa độ chụp đã làm tròn, chỉ lưu khi người upload đồng ý
+type ImageLocation struct {
+	Latitude  float64 `json:"latitude" firestore:"latitude"`
+	Longitude float64 `json:"longitude" firestore:"longitude"`
+}
+
+// ImageVariant là một bản của ảnh trên blob store
+type ImageVariant struct {
+	URL         string `json:"url" firestore:"url"`
+	Key         string `json:"-" firestore:"key"`
+	ContentType string `json:"content_type" firestore:"content_type"`
+	Width       int    `json:"width" firestore:"width"`
+	Height      int    `json:"height" firestore:"height"`
+	Bytes       int64  `json:"bytes" firestore:"bytes"`
+}
+
+// ImageUpload là một lượt upload thẳng lên blob store đang chờ xác nhận, lưu trong collection image_upload.
+// File nằm ở Key (thư mục uploads/) cho tới khi được xác nhận và chuyển thành ImageAsset.
+type ImageUpload struct {
+	ID          string    `json:"id" firestore:"id"`
+	Key         string    `json:"key" firestore:"key"`
+	ContentType string    `json:"content_type" firestore:"content_type"`
+	Size        int64     `json:"size" firestore:"size"`
+	CreatedBy   string    `json:"created_by" firestore:"created_by"`
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at" firestore:"expires_at"`
+}
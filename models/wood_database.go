@@ -1,9 +1,25 @@
 package models
 
+import "time"
+
+// WoodDatabaseReadOnlyFields là các field client không được ghi qua PATCH
+var WoodDatabaseReadOnlyFields = []string{"id", "size", "image", "cover_piece_id", "created_at", "updated_at", "created_by", "deleted_at", "deleted_by"}
+
+// Rule validate khai báo trong tag `binding` (xem package validation)
 type WoodDatabase struct {
-	ID          string `firestore:"id"`
-	Title       string `firestore:"title"`
-	Size        int    `firestore:"size"`
-	Description string `firestore:"description"`
-	Image       string `firestore:"image"`
+	ID          string `json:"id" firestore:"id" binding:"required,max=64,libid"`
+	Title       string `json:"title" firestore:"title" binding:"required,max=200"`
+	Size        int    `json:"size" firestore:"size"`
+	Description string `json:"description" firestore:"description" binding:"max=5000"`
+
+	// Ảnh bìa được chọn từ ảnh của một piece trong database (API cover), giá trị gửi qua create/update bị bỏ qua
+	Image        string `json:"image" firestore:"image"`
+	CoverPieceID string `json:"cover_piece_id,omitempty" firestore:"cover_piece_id,omitempty"`
+
+	// Các field do server quản lý, giá trị client gửi lên bị bỏ qua
+	CreatedAt time.Time  `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" firestore:"updated_at,serverTimestamp"`
+	CreatedBy string     `json:"created_by" firestore:"created_by"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" firestore:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty" firestore:"deleted_by,omitempty"`
 }
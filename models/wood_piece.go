@@ -1,9 +1,47 @@
 package models
 
+import "time"
+
+// WoodPieceReadOnlyFields là các field client không được ghi qua PATCH
+var WoodPieceReadOnlyFields = []string{"id", "images", "cover", "created_at", "updated_at", "created_by", "deleted_at", "deleted_by"}
+
+// MaxPieceImages là số ảnh tối đa của một piece, khớp rule max của ImageUrls
+const MaxPieceImages = 100
+
+// Kiểu mặt cắt/hướng chụp của ảnh mẫu gỗ
+const (
+	ImageViewEndGrain    = "end_grain"    // mặt cắt ngang
+	ImageViewFlatSawn    = "flat_sawn"    // mặt xẻ tiếp tuyến
+	ImageViewQuarterSawn = "quarter_sawn" // mặt xẻ xuyên tâm
+	ImageViewOther       = "other"
+)
+
+// PieceImage là thông tin đi kèm một ảnh trong ImageUrls
+type PieceImage struct {
+	URL     string `json:"url" firestore:"url"`
+	Caption string `json:"caption,omitempty" firestore:"caption,omitempty"`
+	View    string `json:"view,omitempty" firestore:"view,omitempty"`
+	// Độ phóng đại khi chụp (ví dụ 10 là 10x), 0 là không rõ
+	Magnification float64 `json:"magnification,omitempty" firestore:"magnification,omitempty"`
+}
+
+// Rule validate khai báo trong tag `binding` (xem package validation)
 type WoodPiece struct {
 	ID          string   `json:"id" firestore:"id"`
-	DatabaseID  string   `json:"database_id" firestore:"database_id"`
-	Name        string   `json:"name" firestore:"name"`
-	Description string   `json:"description" firestore:"description"`
-	ImageUrls   []string `json:"image_urls" firestore:"image_urls"`
+	DatabaseID  string   `json:"database_id" firestore:"database_id" binding:"required,max=64,libid"`
+	Name        string   `json:"name" firestore:"name" binding:"required,max=200"`
+	Description string   `json:"description" firestore:"description" binding:"max=5000"`
+	ImageUrls   []string `json:"image_urls" firestore:"image_urls" binding:"max=100,dive,max=2048,http_url"`
+
+	// Images cùng thứ tự với ImageUrls, Cover là URL ảnh đại diện (rỗng là ảnh đầu tiên).
+	// Cả hai do server quản lý, chỉ sửa qua các API ảnh của piece.
+	Images []PieceImage `json:"images" firestore:"images"`
+	Cover  string       `json:"cover,omitempty" firestore:"cover,omitempty"`
+
+	// Các field do server quản lý, giá trị client gửi lên bị bỏ qua
+	CreatedAt time.Time  `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" firestore:"updated_at,serverTimestamp"`
+	CreatedBy string     `json:"created_by" firestore:"created_by"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" firestore:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty" firestore:"deleted_by,omitempty"`
 }
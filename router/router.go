@@ -1,25 +1,49 @@
 package router
 
 import (
+	"net/http"
+
+	"backend/apperr"
 	"backend/handler"
 	"backend/middleware"
+	"backend/storage"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func SetupRouter() *gin.Engine {
-	r := gin.Default()
+	return newRouter(middleware.AuthMiddleware())
+}
+
+// newRouter dựng router với middleware xác thực auth (test thay bằng bản giả để gọi tới handler)
+func newRouter(auth gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Logger())
+	r.Use(middleware.RequestID())
+	r.Use(middleware.ErrorHandler())
+	// Recovery đứng sau ErrorHandler để panic cũng được trả về theo định dạng lỗi chung
+	r.Use(middleware.Recovery())
+	r.NoRoute(func(c *gin.Context) {
+		_ = c.Error(apperr.NotFound("Route not found"))
+	})
 
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "If-Match", "If-None-Match", "X-Request-ID", "Last-Event-ID"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "X-Request-ID"},
 		AllowCredentials: true,
 	}))
 
+	// Backend lưu trữ local: server tự phục vụ ảnh và model đã lưu
+	if local, ok := storage.Default.(*storage.LocalStore); ok {
+		r.GET("/blobs/*key", gin.WrapH(http.StripPrefix("/blobs", local)))
+		r.HEAD("/blobs/*key", gin.WrapH(http.StripPrefix("/blobs", local)))
+		r.PUT("/blobs/*key", gin.WrapH(http.StripPrefix("/blobs", local)))
+	}
+
 	model := r.Group("/model-api")
 	{
 		model.GET("/version", handler.GetModelVersion)
@@ -28,26 +52,63 @@ func SetupRouter() *gin.Engine {
 		model.POST("/upload", handler.UploadNewModel)
 	}
 
+	// Sửa nhãn của model - yêu cầu đăng nhập
+	modelAuth := model.Group("")
+	modelAuth.Use(auth)
+	{
+		modelAuth.PUT("/labels", handler.SetModelLabels)
+	}
+
 	// Protected routes - yêu cầu đăng nhập
 	library := r.Group("/library-api")
-	library.Use(middleware.AuthMiddleware()) // Thêm middleware auth
+	library.Use(auth) // Thêm middleware auth
 	{
 		// Upload image
 		library.POST("/upload_image", handler.UploadImage)
+		library.GET("/image/get", handler.GetImage)
+		library.POST("/upload/sign", handler.SignUpload)
+		library.POST("/upload/confirm", handler.ConfirmUpload)
+		library.GET("/image/duplicates", handler.ListDuplicateImages)
+		library.GET("/image/orphans", handler.ListOrphanImages)
+		library.POST("/image/gc", handler.CollectOrphanImages)
+		library.POST("/search/similar", handler.SearchSimilar)
 
 		// Wood Database (Collection) - RESTful APIs
 		library.GET("/database/list", handler.ListWoodDatabase)
 		library.GET("/database/get", handler.GetWoodDatabase)
 		library.POST("/database/create", handler.CreateWoodDatabase)
 		library.PUT("/database/update/:id", handler.UpdateWoodDatabase)
+		library.PATCH("/database/update/:id", handler.PatchWoodDatabase)
 		library.DELETE("/database/delete", handler.DeleteWoodDatabase)
+		library.PUT("/database/:id/cover", handler.SetDatabaseCover)
 
 		// Wood Piece - RESTful APIs
 		library.GET("/piece/list", handler.ListWoodPiecesByDatabase)
 		library.GET("/piece/get", handler.GetWoodPiece)
 		library.POST("/piece/create", handler.CreateWoodPiece)
 		library.PUT("/piece/update/:id", handler.UpdateWoodPiece)
+		library.PATCH("/piece/update/:id", handler.PatchWoodPiece)
 		library.DELETE("/piece/delete", handler.DeleteWoodPiece)
+		library.POST("/piece/:id/images", handler.UploadPieceImages)
+		library.PUT("/piece/:id/images/order", handler.ReorderPieceImages)
+		library.PUT("/piece/:id/image", handler.UpdatePieceImage)
+		library.PUT("/piece/:id/cover", handler.SetPieceCover)
+
+		// Import hàng loạt từ CSV/JSON Lines/ZIP, export backup
+		library.POST("/import", handler.ImportLibrary)
+		library.GET("/export", handler.ExportLibrary)
+
+		// Offline bundle và delta sync cho app
+		library.GET("/offline/bundle", handler.GetOfflineBundle)
+		library.GET("/sync", handler.Sync)
+
+		// Change feed (Server-Sent Events) cho dashboard
+		library.GET("/events", handler.StreamChanges)
+
+		// Trash - item bị xóa mềm
+		library.GET("/trash", handler.ListTrash)
+		library.POST("/database/restore/:id", handler.RestoreWoodDatabase)
+		library.POST("/piece/restore/:id", handler.RestoreWoodPiece)
 	}
 
 	return r
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Contract test: mọi lỗi của API đều trả về cùng một dạng
+// {"error": {"code", "message", "request_id", "details"?}}, details là danh sách {"field", "code", "message"}.
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeAuth thay cho middleware xác thực Firebase để request tới được handler
+func fakeAuth(c *gin.Context) {
+	c.Set("uid", "test-user")
+	c.Next()
+}
+
+// routePath điền giá trị cho tham số của route, vd /piece/:id/cover -> /piece/x/cover
+func routePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			parts[i] = "x"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isProtected(route gin.RouteInfo) bool {
+	return strings.HasPrefix(route.Path, "/library-api/") ||
+		(route.Method == http.MethodPut && route.Path == "/model-api/labels")
+}
+
+func multipartBody(t *testing.T, fields map[string]string) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), w.FormDataContentType()
+}
+
+// assertErrorEnvelope kiểm tra status, mã lỗi và đúng định dạng lỗi chung
+func assertErrorEnvelope(t *testing.T, w *httptest.ResponseRecorder, wantStatus int, wantCode string) {
+	t.Helper()
+	if w.Code != wantStatus {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, wantStatus, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body is not a JSON object: %v (%s)", err, w.Body.String())
+	}
+	if len(body) != 1 || body["error"] == nil {
+		t.Fatalf("body must only contain \"error\": %s", w.Body.String())
+	}
+
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body["error"], &envelope); err != nil {
+		t.Fatalf("error is not an object: %s", body["error"])
+	}
+	for key := range envelope {
+		switch key {
+		case "code", "message", "request_id", "details":
+		default:
+			t.Errorf("unexpected key %q in error", key)
+		}
+	}
+
+	var e struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+		Details   []map[string]string
+	}
+	if err := json.Unmarshal(body["error"], &e); err != nil {
+		t.Fatalf("error has wrong field types: %v (%s)", err, body["error"])
+	}
+	if e.Code != wantCode {
+		t.Errorf("code = %q, want %q", e.Code, wantCode)
+	}
+	if e.Message == "" {
+		t.Error("message is empty")
+	}
+	if e.RequestID == "" || e.RequestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("request_id = %q, header X-Request-ID = %q", e.RequestID, w.Header().Get("X-Request-ID"))
+	}
+	if wantCode == "validation_failed" && len(e.Details) == 0 {
+		t.Error("validation error without details")
+	}
+	for _, d := range e.Details {
+		if len(d) != 3 || d["field"] == "" || d["code"] == "" || d["message"] == "" {
+			t.Errorf("detail must have field, code and message: %v", d)
+		}
+	}
+}
+
+func TestProtectedRoutesRequireAuth(t *testing.T) {
+	r := SetupRouter()
+
+	protected := 0
+	for _, route := range r.Routes() {
+		if !isProtected(route) {
+			continue
+		}
+		protected++
+		for _, header := range []string{"", "Token abc"} {
+			t.Run(route.Method+" "+route.Path+" "+header, func(t *testing.T) {
+				req := httptest.NewRequest(route.Method, routePath(route.Path), nil)
+				if header != "" {
+					req.Header.Set("Authorization", header)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				assertErrorEnvelope(t, w, http.StatusUnauthorized, "unauthorized")
+			})
+		}
+	}
+	if protected == 0 {
+		t.Fatal("no protected routes found")
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	r := newRouter(fakeAuth)
+	r.GET("/test/panic", func(c *gin.Context) { panic("boom") })
+
+	noFile, noFileType := multipartBody(t, map[string]string{"record_location": "true"})
+	validDB := `{"id": "oak", "title": "Oak"}`
+
+	cases := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		header      map[string]string
+		status      int
+		code        string
+	}{
+		{name: "unknown route", method: "GET", path: "/library-api/nope", status: 404, code: "not_found"},
+		{name: "handler panic", method: "GET", path: "/test/panic", status: 500, code: "internal"},
+
+		{name: "create database invalid json", method: "POST", path: "/library-api/database/create", body: "{", status: 400, code: "bad_request"},
+		{name: "create database missing fields", method: "POST", path: "/library-api/database/create", body: "{}", status: 400, code: "validation_failed"},
+		{name: "create database bad id", method: "POST", path: "/library-api/database/create", body: `{"id": "a b", "title": "x"}`, status: 400, code: "validation_failed"},
+		{name: "update database stale etag", method: "PUT", path: "/library-api/database/update/oak", body: validDB, header: map[string]string{"If-Match": `W/"1"`}, status: 412, code: "precondition_failed"},
+		{name: "patch database not object", method: "PATCH", path: "/library-api/database/update/oak", body: "[]", status: 400, code: "bad_request"},
+		{name: "patch database read-only", method: "PATCH", path: "/library-api/database/update/oak", body: `{"size": 3, "image": "https://a/b.jpg"}`, status: 400, code: "validation_failed"},
+		{name: "patch database unknown field", method: "PATCH", path: "/library-api/database/update/oak", body: `{"colour": "red"}`, status: 400, code: "validation_failed"},
+		{name: "get database without id", method: "GET", path: "/library-api/database/get", status: 400, code: "bad_request"},
+		{name: "delete database without id", method: "DELETE", path: "/library-api/database/delete", status: 400, code: "bad_request"},
+		{name: "database cover invalid json", method: "PUT", path: "/library-api/database/oak/cover", body: "{", status: 400, code: "bad_request"},
+
+		{name: "create piece missing fields", method: "POST", path: "/library-api/piece/create", body: "{}", status: 400, code: "validation_failed"},
+		{name: "create piece bad image url", method: "POST", path: "/library-api/piece/create", body: `{"database_id": "oak", "name": "a", "image_urls": ["ftp://x"]}`, status: 400, code: "validation_failed"},
+		{name: "update piece invalid json", method: "PUT", path: "/library-api/piece/update/oak_01", body: "{", status: 400, code: "bad_request"},
+		{name: "patch piece read-only images", method: "PATCH", path: "/library-api/piece/update/oak_01", body: `{"images": [], "cover": "x"}`, status: 400, code: "validation_failed"},
+		{name: "get piece without id", method: "GET", path: "/library-api/piece/get", status: 400, code: "bad_request"},
+		{name: "list pieces without database", method: "GET", path: "/library-api/piece/list", status: 400, code: "bad_request"},
+		{name: "delete piece without id", method: "DELETE", path: "/library-api/piece/delete", status: 400, code: "bad_request"},
+		{name: "reorder images missing list", method: "PUT", path: "/library-api/piece/oak_01/images/order", body: "{}", status: 400, code: "validation_failed"},
+		{name: "image info bad view", method: "PUT", path: "/library-api/piece/oak_01/image", body: `{"url": "https://a/b.jpg", "view": "sideways"}`, status: 400, code: "validation_failed"},
+		{name: "piece cover invalid json", method: "PUT", path: "/library-api/piece/oak_01/cover", body: "{", status: 400, code: "bad_request"},
+		{name: "piece images without files", method: "POST", path: "/library-api/piece/oak_01/images", contentType: noFileType, body: noFile, status: 400, code: "bad_request"},
+
+		{name: "upload image without file", method: "POST", path: "/library-api/upload_image", contentType: noFileType, body: noFile, status: 400, code: "bad_request"},
+		{name: "get image without url", method: "GET", path: "/library-api/image/get", status: 400, code: "bad_request"},
+		{name: "duplicates bad distance", method: "GET", path: "/library-api/image/duplicates?distance=abc", status: 400, code: "validation_failed"},
+		{name: "sign upload missing fields", method: "POST", path: "/library-api/upload/sign", body: "{}", status: 400, code: "validation_failed"},
+		{name: "sign upload without blob store", method: "POST", path: "/library-api/upload/sign", body: `{"content_type": "image/jpeg", "size": 10}`, status: 501, code: "not_implemented"},
+		{name: "confirm upload missing id", method: "POST", path: "/library-api/upload/confirm", body: "{}", status: 400, code: "validation_failed"},
+		{name: "similar search missing url", method: "POST", path: "/library-api/search/similar", body: "{}", status: 400, code: "validation_failed"},
+		{name: "similar search without file", method: "POST", path: "/library-api/search/similar", contentType: noFileType, body: noFile, status: 400, code: "bad_request"},
+
+		{name: "import without file", method: "POST", path: "/library-api/import", contentType: noFileType, body: noFile, status: 400, code: "bad_request"},
+		{name: "sync bad token", method: "GET", path: "/library-api/sync?since=not-a-token", status: 400, code: "bad_request"},
+
+		{name: "activate model without version", method: "POST", path: "/model-api/activate", status: 400, code: "bad_request"},
+		{name: "upload model without file", method: "POST", path: "/model-api/upload", contentType: noFileType, body: noFile, status: 400, code: "bad_request"},
+		{name: "set labels without version", method: "PUT", path: "/model-api/labels", body: "[]", status: 400, code: "bad_request"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			contentType := tc.contentType
+			if contentType == "" && tc.body != "" {
+				contentType = "application/json"
+			}
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assertErrorEnvelope(t, w, tc.status, tc.code)
+		})
+	}
+}
+
+func TestClientRequestIDIsEchoed(t *testing.T) {
+	r := newRouter(fakeAuth)
+	req := httptest.NewRequest("GET", "/library-api/database/get", nil)
+	req.Header.Set("X-Request-ID", "client-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assertErrorEnvelope(t, w, http.StatusBadRequest, "bad_request")
+	if !strings.Contains(w.Body.String(), `"request_id":"client-123"`) {
+		t.Errorf("request_id from client not echoed: %s", w.Body.String())
+	}
+}
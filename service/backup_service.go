@@ -0,0 +1,452 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"backend/firestore"
+	"backend/models"
+)
+
+// Định dạng archive backup. Tăng BackupVersion khi thay đổi cấu trúc archive;
+// RestoreLibrary chỉ đọc archive có version không lớn hơn version hiện tại.
+const (
+	BackupFormat  = "wood-library-backup"
+	BackupVersion = 1
+
+	backupManifestFile  = "manifest.json"
+	backupDatabasesFile = "databases.jsonl"
+	backupPiecesFile    = "pieces.jsonl"
+	backupImagesDir     = "images/"
+)
+
+var imageHTTPClient = &http.Client{Timeout: time.Minute}
+
+// BackupFile là một file trong archive kèm checksum để kiểm tra khi khôi phục
+type BackupFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupManifest mô tả nội dung archive (file manifest.json)
+type BackupManifest struct {
+	Format        string            `json:"format"`
+	Version       int               `json:"version"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Databases     int               `json:"databases"`
+	Pieces        int               `json:"pieces"`
+	IncludeImages bool              `json:"include_images"`
+	Files         []BackupFile      `json:"files"`
+	Images        map[string]string `json:"images,omitempty"`         // URL ảnh -> đường dẫn trong archive
+	MissingImages []string          `json:"missing_images,omitempty"` // ảnh không tải về được lúc export
+}
+
+// ExportLibrary ghi toàn bộ database và piece (kể cả item trong thùng rác) ra archive ZIP.
+// includeImages tải kèm file ảnh từ URL; ảnh lỗi được ghi vào MissingImages chứ không làm hỏng bản export.
+func ExportLibrary(w io.Writer, includeImages bool) (*BackupManifest, error) {
+	dbs, err := firestore.List[models.WoodDatabase]("wood_database")
+	if err != nil {
+		return nil, err
+	}
+	pieces, err := firestore.List[models.WoodPiece]("wood_piece")
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BackupManifest{
+		Format:        BackupFormat,
+		Version:       BackupVersion,
+		CreatedAt:     time.Now().UTC(),
+		Databases:     len(dbs),
+		Pieces:        len(pieces),
+		IncludeImages: includeImages,
+	}
+
+	zw := zip.NewWriter(w)
+	if err := writeBackupJSONL(zw, manifest, backupDatabasesFile, dbs); err != nil {
+		return nil, err
+	}
+	if err := writeBackupJSONL(zw, manifest, backupPiecesFile, pieces); err != nil {
+		return nil, err
+	}
+
+	if includeImages {
+		manifest.Images = make(map[string]string)
+		for _, url := range libraryImageURLs(dbs, pieces) {
+			name := backupImagePath(url)
+			file, err := writeBackupEntry(zw, name, func(w io.Writer) error { return downloadImage(url, w) })
+			if err != nil {
+				log.Printf("export: cannot fetch image %s: %v", url, err)
+				manifest.MissingImages = append(manifest.MissingImages, url)
+				continue
+			}
+			manifest.Files = append(manifest.Files, file)
+			manifest.Images[url] = name
+		}
+	}
+
+	mw, err := zw.Create(backupManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, zw.Close()
+}
+
+func writeBackupJSONL[T any](zw *zip.Writer, manifest *BackupManifest, name string, docs []T) error {
+	file, err := writeBackupEntry(zw, name, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, doc := range docs {
+			if err := enc.Encode(doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, file)
+	return nil
+}
+
+// writeBackupEntry ghi một file vào archive và tính checksum trong lúc ghi.
+// Nếu write lỗi giữa chừng, file dở dang vẫn nằm trong ZIP nhưng không có trong manifest.
+func writeBackupEntry(zw *zip.Writer, name string, write func(io.Writer) error) (BackupFile, error) {
+	fw, err := zw.Create(name)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(fw, h)}
+	if err := write(cw); err != nil {
+		return BackupFile{}, err
+	}
+	return BackupFile{Path: name, Size: cw.n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// libraryImageURLs trả về các URL ảnh (không trùng) của database và piece theo thứ tự xuất hiện
+func libraryImageURLs(dbs []models.WoodDatabase, pieces []models.WoodPiece) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	for _, db := range dbs {
+		add(db.Image)
+	}
+	for _, p := range pieces {
+		for _, url := range p.ImageUrls {
+			add(url)
+		}
+	}
+	return urls
+}
+
+// backupImagePath đặt tên file ảnh trong archive theo hash của URL để không trùng và không lộ cấu trúc thư mục gốc
+func backupImagePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	ext := strings.ToLower(path.Ext(strings.SplitN(url, "?", 2)[0]))
+	if len(ext) > 5 {
+		ext = ""
+	}
+	return backupImagesDir + hex.EncodeToString(sum[:16]) + ext
+}
+
+func downloadImage(url string, w io.Writer) error {
+	resp, err := imageHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// RestoreOptions là tùy chọn khi khôi phục archive
+type RestoreOptions struct {
+	Strategy firestore.ConflictStrategy
+	// UploadImages tải ảnh trong archive lên blob store và thay URL trong dữ liệu,
+	// dùng khi khôi phục sang môi trường không truy cập được ảnh gốc
+	UploadImages bool
+}
+
+// RestoreCount đếm document đã ghi và bị bỏ qua (do đã tồn tại) của một collection
+type RestoreCount struct {
+	Written int `json:"written"`
+	Skipped int `json:"skipped"`
+}
+
+// RestoreReport tổng kết một lần khôi phục
+type RestoreReport struct {
+	Databases      RestoreCount `json:"databases"`
+	Pieces         RestoreCount `json:"pieces"`
+	ImagesUploaded int          `json:"images_uploaded"`
+	SizesFixed     int          `json:"sizes_fixed"`
+}
+
+// RestoreLibrary kiểm tra checksum rồi ghi lại database và piece từ archive do ExportLibrary tạo.
+// Với ConflictFail, nếu có document đã tồn tại thì trả lỗi trước khi ghi bất cứ thứ gì.
+// Sau khi ghi, counter ID của piece được nâng lên và size của các database được tính lại.
+func RestoreLibrary(r io.ReaderAt, size int64, opts RestoreOptions) (*RestoreReport, error) {
+	switch opts.Strategy {
+	case firestore.ConflictSkip, firestore.ConflictOverwrite, firestore.ConflictFail:
+	default:
+		return nil, fmt.Errorf("unknown conflict strategy %q (expected skip, overwrite or fail)", opts.Strategy)
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	manifest, err := readBackupManifest(files)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyBackupFiles(files, manifest); err != nil {
+		return nil, err
+	}
+
+	dbs, err := readBackupJSONL[models.WoodDatabase](files[backupDatabasesFile])
+	if err != nil {
+		return nil, err
+	}
+	pieces, err := readBackupJSONL[models.WoodPiece](files[backupPiecesFile])
+	if err != nil {
+		return nil, err
+	}
+
+	dbIDs := make([]string, len(dbs))
+	for i, db := range dbs {
+		dbIDs[i] = db.ID
+	}
+	pieceIDs := make([]string, len(pieces))
+	for i, p := range pieces {
+		pieceIDs[i] = p.ID
+	}
+
+	if opts.Strategy == firestore.ConflictFail {
+		if err := ensureNoExisting("wood_database", dbIDs); err != nil {
+			return nil, err
+		}
+		if err := ensureNoExisting("wood_piece", pieceIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &RestoreReport{}
+	if opts.UploadImages {
+		uploaded, err := uploadBackupImages(files, manifest)
+		report.ImagesUploaded = len(uploaded)
+		if err != nil {
+			return report, err
+		}
+		for i := range dbs {
+			dbs[i].Image = replaceURL(dbs[i].Image, uploaded)
+		}
+		for i := range pieces {
+			for j, url := range pieces[i].ImageUrls {
+				pieces[i].ImageUrls[j] = replaceURL(url, uploaded)
+			}
+			for j := range pieces[i].Images {
+				pieces[i].Images[j].URL = replaceURL(pieces[i].Images[j].URL, uploaded)
+			}
+			pieces[i].Cover = replaceURL(pieces[i].Cover, uploaded)
+		}
+	}
+
+	// updated_at lấy thời điểm ghi lại (serverTimestamp) để client delta sync thấy các document được khôi phục
+	for i := range dbs {
+		dbs[i].UpdatedAt = time.Time{}
+	}
+	for i := range pieces {
+		pieces[i].UpdatedAt = time.Time{}
+	}
+
+	report.Databases.Written, report.Databases.Skipped, err = firestore.RestoreDocuments("wood_database", dbIDs, dbs, opts.Strategy)
+	if err != nil {
+		return report, fmt.Errorf("restore wood databases: %w", err)
+	}
+	report.Pieces.Written, report.Pieces.Skipped, err = firestore.RestoreDocuments("wood_piece", pieceIDs, pieces, opts.Strategy)
+	if err != nil {
+		return report, fmt.Errorf("restore wood pieces: %w", err)
+	}
+
+	// Counter phải lớn hơn mọi số thứ tự đã dùng trong backup để piece mới không trùng ID
+	lastIndex := make(map[string]int)
+	for _, p := range pieces {
+		if index, ok := firestore.PieceIndex(p.DatabaseID, p.ID); ok && index > lastIndex[p.DatabaseID] {
+			lastIndex[p.DatabaseID] = index
+		}
+	}
+	for databaseID, index := range lastIndex {
+		if err := firestore.RaisePieceCounter(databaseID, index); err != nil {
+			return report, fmt.Errorf("update piece counter of %s: %w", databaseID, err)
+		}
+	}
+
+	for _, id := range dbIDs {
+		oldSize, newSize, err := firestore.RecomputeWoodDatabaseSize(id)
+		if err != nil {
+			return report, fmt.Errorf("recompute size of %s: %w", id, err)
+		}
+		if oldSize != newSize {
+			report.SizesFixed++
+		}
+	}
+	return report, nil
+}
+
+func readBackupManifest(files map[string]*zip.File) (*BackupManifest, error) {
+	f, ok := files[backupManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("invalid archive: %s not found", backupManifestFile)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var manifest BackupManifest
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Format != BackupFormat {
+		return nil, fmt.Errorf("not a wood library backup (format %q)", manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > BackupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d (this build supports up to %d)", manifest.Version, BackupVersion)
+	}
+	return &manifest, nil
+}
+
+// verifyBackupFiles kiểm tra mọi file trong manifest tồn tại và đúng kích thước, checksum
+func verifyBackupFiles(files map[string]*zip.File, manifest *BackupManifest) error {
+	for _, required := range []string{backupDatabasesFile, backupPiecesFile} {
+		if !slices.ContainsFunc(manifest.Files, func(f BackupFile) bool { return f.Path == required }) {
+			return fmt.Errorf("invalid manifest: %s is not listed", required)
+		}
+	}
+
+	for _, entry := range manifest.Files {
+		f, ok := files[entry.Path]
+		if !ok {
+			return fmt.Errorf("archive is missing %s", entry.Path)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", entry.Path, err)
+		}
+		if n != entry.Size || hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+			return fmt.Errorf("checksum mismatch for %s, the archive is corrupted", entry.Path)
+		}
+	}
+	return nil
+}
+
+func readBackupJSONL[T any](f *zip.File) ([]T, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var docs []T
+	dec := json.NewDecoder(bufio.NewReader(rc))
+	for dec.More() {
+		var doc T
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func ensureNoExisting(collection string, ids []string) error {
+	existing, err := firestore.ExistingDocumentIDs(collection, ids)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		shown := existing[:min(len(existing), 5)]
+		return fmt.Errorf("%d documents already exist in %s (e.g. %s), nothing was written",
+			len(existing), collection, strings.Join(shown, ", "))
+	}
+	return nil
+}
+
+// uploadBackupImages tải các ảnh trong archive lên blob store, trả về map URL cũ -> URL mới
+func uploadBackupImages(files map[string]*zip.File, manifest *BackupManifest) (map[string]string, error) {
+	uploaded := make(map[string]string, len(manifest.Images))
+	for url, name := range manifest.Images {
+		f, ok := files[name]
+		if !ok {
+			return uploaded, fmt.Errorf("archive is missing %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return uploaded, err
+		}
+		asset, err := UploadImage(context.Background(), rc, UploadOptions{CreatedBy: "restore", AllowDuplicate: true})
+		rc.Close()
+		if err != nil {
+			return uploaded, fmt.Errorf("upload %s: %w", name, err)
+		}
+		uploaded[url] = asset.URL
+	}
+	return uploaded, nil
+}
+
+func replaceURL(url string, replacements map[string]string) string {
+	if newURL, ok := replacements[url]; ok {
+		return newURL
+	}
+	return url
+}
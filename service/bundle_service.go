@@ -0,0 +1,325 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+)
+
+const (
+	// Cạnh của ảnh thu nhỏ trong offline bundle (px)
+	bundleThumbnailSize = 256
+	// Dung lượng tối đa của một ảnh nhúng vào bundle
+	bundleMaxEmbeddedThumbnail = 64 << 10
+	// Số ảnh thu nhỏ được tải cùng lúc khi nhúng
+	bundleFetchWorkers = 8
+)
+
+// OfflineBundle là toàn bộ dữ liệu thư viện app cần để nhận diện gỗ khi không có mạng
+type OfflineBundle struct {
+	// Version là hash trạng thái thư viện (xem bundleState), không phụ thuộc việc nhúng ảnh thu nhỏ thành công hay không
+	Version     string           `json:"version"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Model       *BundleModel     `json:"model,omitempty"`
+	Databases   []BundleDatabase `json:"databases"`
+	Pieces      []BundlePiece    `json:"pieces"`
+}
+
+// BundleModel là model đang kích hoạt và label map của nó
+type BundleModel struct {
+	Version     int      `json:"version"`
+	Checksum    string   `json:"checksum"`
+	DownloadURL string   `json:"download_url"`
+	Labels      []string `json:"labels,omitempty"`
+}
+
+// BundleDatabase có cùng các field với WoodDatabase của API, thêm ảnh thu nhỏ của ảnh bìa
+type BundleDatabase struct {
+	models.WoodDatabase
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// BundlePiece có cùng các field với WoodPiece của API, thêm ảnh thu nhỏ theo thứ tự image_urls
+type BundlePiece struct {
+	models.WoodPiece
+	Thumbnails []string `json:"thumbnails,omitempty"`
+}
+
+// bundleState là những gì quyết định nội dung bundle. Version được tính từ đây thay vì từ output,
+// để ảnh thu nhỏ nhúng lỗi (giữ nguyên URL) không làm đổi ETag khi dữ liệu không đổi.
+type bundleState struct {
+	Embedded   bool         `json:"embedded"`
+	Model      *BundleModel `json:"model"`
+	UpdatedAt  time.Time    `json:"updated_at"` // thời điểm ghi mới nhất của database/piece, kể cả trong thùng rác
+	Databases  int          `json:"databases"`
+	Pieces     int          `json:"pieces"`
+	Tombstones int          `json:"tombstones"`
+	Thumbnails int          `json:"thumbnails"` // số ảnh đã có bản thumbnail riêng
+}
+
+func newBundleState(dbs []models.WoodDatabase, pieces []models.WoodPiece) bundleState {
+	var state bundleState
+	latest := func(t time.Time, deletedAt *time.Time) {
+		if t.After(state.UpdatedAt) {
+			state.UpdatedAt = t
+		}
+		if deletedAt != nil && deletedAt.After(state.UpdatedAt) {
+			state.UpdatedAt = *deletedAt
+		}
+	}
+	for _, db := range dbs {
+		latest(db.UpdatedAt, db.DeletedAt)
+		if db.DeletedAt == nil {
+			state.Databases++
+		}
+	}
+	for _, p := range pieces {
+		latest(p.UpdatedAt, p.DeletedAt)
+		if p.DeletedAt == nil {
+			state.Pieces++
+		}
+	}
+	return state
+}
+
+func (s bundleState) version() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// BundleBlob là bundle đã mã hóa sẵn để trả về, kèm bản gzip
+type BundleBlob struct {
+	ETag    string
+	JSON    []byte
+	Gzipped []byte
+	BuiltAt time.Time
+}
+
+// bundleCache giữ bundle đã build theo từng biến thể (nhúng ảnh hay không)
+var bundleCache = struct {
+	sync.Mutex
+	blobs map[bool]*BundleBlob
+	// generation tăng mỗi lần invalidate để bundle đang build dở không ghi đè dữ liệu mới
+	generation int
+}{blobs: make(map[bool]*BundleBlob)}
+
+// InvalidateOfflineBundle bỏ bundle đã cache, lần tải sau sẽ build lại
+func InvalidateOfflineBundle() {
+	bundleCache.Lock()
+	defer bundleCache.Unlock()
+	bundleCache.blobs = make(map[bool]*BundleBlob)
+	bundleCache.generation++
+}
+
+// GetOfflineBundle trả về bundle từ cache hoặc build mới nếu cache hết hạn.
+// embedThumbnails nhúng ảnh thu nhỏ dạng data URI thay vì URL.
+func GetOfflineBundle(embedThumbnails bool) (*BundleBlob, error) {
+	bundleCache.Lock()
+	blob := bundleCache.blobs[embedThumbnails]
+	generation := bundleCache.generation
+	bundleCache.Unlock()
+
+	if blob != nil && time.Since(blob.BuiltAt) < config.OfflineBundleTTL {
+		return blob, nil
+	}
+
+	blob, err := buildOfflineBundle(embedThumbnails)
+	if err != nil {
+		return nil, err
+	}
+
+	bundleCache.Lock()
+	if bundleCache.generation == generation {
+		bundleCache.blobs[embedThumbnails] = blob
+	}
+	bundleCache.Unlock()
+	return blob, nil
+}
+
+func buildOfflineBundle(embedThumbnails bool) (*BundleBlob, error) {
+	dbs, err := firestore.List[models.WoodDatabase]("wood_database")
+	if err != nil {
+		return nil, err
+	}
+	pieces, err := firestore.List[models.WoodPiece]("wood_piece")
+	if err != nil {
+		return nil, err
+	}
+	assets, err := firestore.List[models.ImageAsset](imageAssetCollection)
+	if err != nil {
+		return nil, err
+	}
+	// Ảnh đã có bản thumbnail dùng bản đó, ảnh cũ dùng transformation của Cloudinary
+	thumbnails := make(map[string]string, len(assets))
+	for _, a := range assets {
+		if v, ok := a.Variants[models.VariantThumbnail]; ok {
+			thumbnails[a.URL] = v.URL
+		}
+	}
+	thumbnail := func(url string) string {
+		if t, ok := thumbnails[url]; ok {
+			return t
+		}
+		return ThumbnailURL(url, bundleThumbnailSize)
+	}
+
+	bundle := assembleOfflineBundle(dbs, pieces, thumbnail)
+	if meta, err := GetCurrentModelMetadata(); err == nil {
+		bundle.Model = &BundleModel{
+			Version:     meta.Version,
+			Checksum:    meta.Checksum,
+			DownloadURL: meta.DownloadURL,
+			Labels:      meta.Labels,
+		}
+	}
+
+	if embedThumbnails {
+		embedBundleThumbnails(bundle)
+	}
+
+	tombstones, err := firestore.ListDocumentIDs("tombstone")
+	if err != nil {
+		return nil, err
+	}
+	state := newBundleState(dbs, pieces)
+	state.Embedded = embedThumbnails
+	state.Model = bundle.Model
+	state.Tombstones = len(tombstones)
+	state.Thumbnails = len(thumbnails)
+	if bundle.Version, err = state.version(); err != nil {
+		return nil, err
+	}
+	bundle.GeneratedAt = time.Now().UTC()
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var gz bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &BundleBlob{
+		ETag:    `"` + bundle.Version + `"`,
+		JSON:    data,
+		Gzipped: gz.Bytes(),
+		BuiltAt: bundle.GeneratedAt,
+	}, nil
+}
+
+// assembleOfflineBundle dựng phần dữ liệu của bundle từ các database và piece đang hoạt động
+func assembleOfflineBundle(dbs []models.WoodDatabase, pieces []models.WoodPiece, thumbnail func(string) string) *OfflineBundle {
+	bundle := &OfflineBundle{Databases: []BundleDatabase{}, Pieces: []BundlePiece{}}
+	for _, db := range dbs {
+		if db.DeletedAt != nil {
+			continue
+		}
+		bundle.Databases = append(bundle.Databases, BundleDatabase{
+			WoodDatabase: db,
+			Thumbnail:    thumbnailOrEmpty(db.Image, thumbnail),
+		})
+	}
+	for _, p := range pieces {
+		if p.DeletedAt != nil {
+			continue
+		}
+		bp := BundlePiece{WoodPiece: p}
+		for _, url := range p.ImageUrls {
+			bp.Thumbnails = append(bp.Thumbnails, thumbnail(url))
+		}
+		bundle.Pieces = append(bundle.Pieces, bp)
+	}
+
+	// Sắp xếp cố định để cùng dữ liệu luôn cho cùng thứ tự
+	sort.Slice(bundle.Databases, func(i, j int) bool { return bundle.Databases[i].ID < bundle.Databases[j].ID })
+	sort.Slice(bundle.Pieces, func(i, j int) bool { return bundle.Pieces[i].ID < bundle.Pieces[j].ID })
+	return bundle
+}
+
+func thumbnailOrEmpty(url string, thumbnail func(string) string) string {
+	if url == "" {
+		return ""
+	}
+	return thumbnail(url)
+}
+
+// embedBundleThumbnails thay URL ảnh thu nhỏ bằng data URI. Ảnh tải lỗi hoặc quá lớn giữ nguyên URL.
+func embedBundleThumbnails(bundle *OfflineBundle) {
+	var refs []*string
+	for i := range bundle.Databases {
+		if bundle.Databases[i].Thumbnail != "" {
+			refs = append(refs, &bundle.Databases[i].Thumbnail)
+		}
+	}
+	for i := range bundle.Pieces {
+		for j := range bundle.Pieces[i].Thumbnails {
+			refs = append(refs, &bundle.Pieces[i].Thumbnails[j])
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bundleFetchWorkers)
+	for _, ref := range refs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ref *string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			dataURI, err := fetchDataURI(*ref)
+			if err != nil {
+				log.Printf("offline bundle: cannot embed %s: %v", *ref, err)
+				return
+			}
+			*ref = dataURI
+		}(ref)
+	}
+	wg.Wait()
+}
+
+func fetchDataURI(url string) (string, error) {
+	resp, err := imageHTTPClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, bundleMaxEmbeddedThumbnail+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > bundleMaxEmbeddedThumbnail {
+		return "", fmt.Errorf("thumbnail larger than %d bytes", bundleMaxEmbeddedThumbnail)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"backend/models"
+)
+
+// Item của offline bundle có cùng bộ key với WoodDatabase/WoodPiece của API (xem handler/schema_test.go),
+// chỉ thêm ảnh thu nhỏ
+func TestOfflineBundleSchema(t *testing.T) {
+	at := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	deletedAt := at.Add(time.Hour)
+	dbs := []models.WoodDatabase{
+		{ID: "oak", Title: "Oak", Size: 1, Image: "https://a/1.jpg", CoverPieceID: "oak_01", CreatedAt: at, UpdatedAt: at, CreatedBy: "alice"},
+		{ID: "elm", Title: "Elm", DeletedAt: &deletedAt, DeletedBy: "bob"},
+	}
+	pieces := []models.WoodPiece{
+		{
+			ID: "oak_01", DatabaseID: "oak", Name: "Oak 1", ImageUrls: []string{"https://a/1.jpg"},
+			Images: []models.PieceImage{{URL: "https://a/1.jpg"}}, Cover: "https://a/1.jpg",
+			CreatedAt: at, UpdatedAt: at, CreatedBy: "alice",
+		},
+	}
+	bundle := assembleOfflineBundle(dbs, pieces, func(url string) string { return url + "?thumb" })
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Databases []map[string]any `json:"databases"`
+		Pieces    []map[string]any `json:"pieces"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Databases) != 1 || len(body.Pieces) != 1 {
+		t.Fatalf("bundle must only contain active items: %s", data)
+	}
+
+	cases := []struct {
+		name string
+		obj  map[string]any
+		want []string
+	}{
+		{"database", body.Databases[0], []string{"id", "title", "size", "description", "image", "cover_piece_id", "created_at", "updated_at", "created_by", "thumbnail"}},
+		{"piece", body.Pieces[0], []string{"id", "database_id", "name", "description", "image_urls", "images", "cover", "created_at", "updated_at", "created_by", "thumbnails"}},
+	}
+	for _, tc := range cases {
+		got := slices.Sorted(maps.Keys(tc.obj))
+		slices.Sort(tc.want)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s keys = %v\nwant %v", tc.name, got, tc.want)
+		}
+	}
+	if body.Databases[0]["thumbnail"] != "https://a/1.jpg?thumb" {
+		t.Errorf("database thumbnail = %v", body.Databases[0]["thumbnail"])
+	}
+}
+
+// Version chỉ phụ thuộc trạng thái thư viện: ghi mới, trash hay đổi biến thể đều làm đổi version
+func TestOfflineBundleVersion(t *testing.T) {
+	at := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	dbs := []models.WoodDatabase{{ID: "oak", Title: "Oak", UpdatedAt: at}}
+	pieces := []models.WoodPiece{{ID: "oak_01", DatabaseID: "oak", UpdatedAt: at}}
+
+	version := func(s bundleState) string {
+		t.Helper()
+		v, err := s.version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	base := version(newBundleState(dbs, pieces))
+	if again := version(newBundleState(dbs, pieces)); again != base {
+		t.Errorf("version not stable: %s != %s", again, base)
+	}
+
+	trashedAt := at.Add(time.Minute)
+	trashed := []models.WoodPiece{{ID: "oak_01", DatabaseID: "oak", UpdatedAt: at, DeletedAt: &trashedAt}}
+	embedded := newBundleState(dbs, pieces)
+	embedded.Embedded = true
+	purged := newBundleState(dbs, pieces)
+	purged.Tombstones = 1
+
+	for name, s := range map[string]bundleState{
+		"updated":  newBundleState(dbs, []models.WoodPiece{{ID: "oak_01", DatabaseID: "oak", UpdatedAt: at.Add(time.Second)}}),
+		"trashed":  newBundleState(dbs, trashed),
+		"embedded": embedded,
+		"purged":   purged,
+	} {
+		if version(s) == base {
+			t.Errorf("%s: version did not change", name)
+		}
+	}
+}
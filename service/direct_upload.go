@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+	"backend/storage"
+)
+
+// imageUploadCollection lưu các lượt upload trực tiếp chưa được xác nhận (ImageUpload)
+const imageUploadCollection = "image_upload"
+
+var (
+	ErrDirectUploadUnsupported = apperr.New(http.StatusNotImplemented, "not_implemented", "Blob backend does not support direct uploads")
+	ErrUploadNotFound          = apperr.NotFound("Upload not found or already confirmed")
+	ErrUploadIncomplete        = apperr.New(http.StatusConflict, "upload_incomplete", "File has not been uploaded yet")
+	// ErrUploadTypeMismatch trả về khi nội dung file đã upload không đúng định dạng content_type đã khai báo
+	ErrUploadTypeMismatch = apperr.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "Uploaded file does not match the declared content_type")
+)
+
+// DirectUpload là tham số trả cho client để tự upload ảnh lên blob store, sau đó gọi xác nhận với UploadID
+type DirectUpload struct {
+	UploadID string                `json:"upload_id"`
+	Key      string                `json:"key"`
+	Upload   *storage.UploadTicket `json:"upload"`
+}
+
+// CreateDirectUpload cấp tham số upload đã ký cho một ảnh với contentType và dung lượng size khai báo trước.
+// File được ghi vào storage.StagingPrefix (uploads/<id><ext>) và chỉ thành ảnh của thư viện sau ConfirmDirectUpload.
+func CreateDirectUpload(ctx context.Context, contentType string, size int64, createdBy string) (*DirectUpload, error) {
+	uploader, ok := storage.Default.(storage.DirectUploader)
+	if !ok {
+		return nil, ErrDirectUploadUnsupported
+	}
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return nil, ErrUnsupportedImage
+	}
+	if size > config.ImageMaxBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	id := randomHex(16)
+	key := storage.StagingPrefix + id + ext
+	ticket, err := uploader.PresignUpload(ctx, key, contentType, size, config.DirectUploadTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &models.ImageUpload{
+		ID:          id,
+		Key:         key,
+		ContentType: contentType,
+		Size:        size,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   ticket.ExpiresAt,
+	}
+	if err := firestore.CreateDocument(imageUploadCollection, id, upload); err != nil {
+		return nil, err
+	}
+	return &DirectUpload{UploadID: id, Key: key, Upload: ticket}, nil
+}
+
+// ConfirmDirectUpload kiểm tra dung lượng và Content-Type của file đã upload theo uploadID (Stat, chưa tải về),
+// rồi tải file về, kiểm tra định dạng thật khớp content_type đã khai báo và xử lý như UploadImage (bỏ metadata,
+// sinh bản dẫn xuất), sau đó xóa file tạm ngay. Nếu pieceID khác rỗng, ảnh được thêm vào image_urls của piece
+// và trả về piece sau khi cập nhật. Chỉ người tạo lượt upload mới xác nhận được.
+func ConfirmDirectUpload(ctx context.Context, uploadID, pieceID string, opts UploadOptions) (*models.ImageAsset, *models.WoodPiece, error) {
+	upload, _, err := firestore.Get[models.ImageUpload](imageUploadCollection, uploadID)
+	if errors.Is(err, firestore.ErrNotFound) || (err == nil && upload.CreatedBy != opts.CreatedBy) {
+		return nil, nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if pieceID != "" {
+		piece, _, err := firestore.Get[models.WoodPiece]("wood_piece", pieceID)
+		if errors.Is(err, firestore.ErrNotFound) || (err == nil && piece.DeletedAt != nil) {
+			return nil, nil, firestore.ErrPieceNotFound
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	obj, err := storage.Default.Stat(ctx, upload.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrUploadIncomplete
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var asset *models.ImageAsset
+	contentType, _, _ := mime.ParseMediaType(obj.ContentType)
+	switch {
+	case obj.Size > upload.Size:
+		err = ErrImageTooLarge
+	case contentType != "" && contentType != upload.ContentType:
+		err = ErrUploadTypeMismatch
+	default:
+		asset, err = uploadStaged(ctx, upload, opts)
+	}
+	// File tạm không còn dùng khi đã xử lý xong hoặc bị từ chối vì nội dung; lỗi tạm thời thì giữ lại để thử lại
+	var rejected *apperr.Error
+	if err == nil || errors.As(err, &rejected) {
+		discardUpload(upload)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if pieceID == "" {
+		return asset, nil, nil
+	}
+
+	piece, err := firestore.AddWoodPieceImages(pieceID, []string{asset.URL})
+	if err != nil {
+		// Không gắn được thì để GC dọn ảnh như ảnh chưa dùng
+		return nil, nil, err
+	}
+	if err := setImageOwners(asset, "wood_piece/"+pieceID); err != nil {
+		return nil, nil, err
+	}
+	return asset, piece, nil
+}
+
+// uploadStaged đọc file tạm trên blob store, kiểm tra định dạng nhận dạng từ nội dung (không tin Content-Type
+// backend báo về, vd Cloudinary) khớp với content_type đã khai báo rồi lưu như UploadImage
+func uploadStaged(ctx context.Context, upload *models.ImageUpload, opts UploadOptions) (*models.ImageAsset, error) {
+	rc, _, err := storage.Default.Get(ctx, upload.Key)
+	if err != nil {
+		return nil, fmt.Errorf("read staged upload %s: %w", upload.Key, err)
+	}
+	defer rc.Close()
+
+	data, info, err := ReadImage(rc)
+	if err != nil {
+		return nil, err
+	}
+	if info.ContentType != upload.ContentType {
+		return nil, ErrUploadTypeMismatch
+	}
+	return storeImage(ctx, data, info, opts)
+}
+
+// setImageOwners ghi owner cho ảnh vừa được gắn, không phải chờ lần chạy GC tiếp theo
+func setImageOwners(asset *models.ImageAsset, owners ...string) error {
+	asset.Owners, asset.UnreferencedSince = owners, nil
+	return firestore.UpdateFields(imageAssetCollection, asset.ID, map[string]interface{}{
+		"owners":             owners,
+		"unreferenced_since": nil,
+	})
+}
+
+// discardUpload xóa file tạm và bản ghi của lượt upload, lỗi chỉ được log vì GC sẽ dọn lại
+func discardUpload(upload *models.ImageUpload) {
+	if err := storage.Default.Delete(context.Background(), upload.Key); err != nil {
+		log.Printf("cannot delete staged upload %s: %v", upload.Key, err)
+		return
+	}
+	if err := firestore.DeleteDocument(imageUploadCollection, upload.ID); err != nil {
+		log.Printf("cannot delete upload record %s: %v", upload.ID, err)
+	}
+}
+
+// purgeExpiredUploads xóa các lượt upload chưa được xác nhận sau khi hết hạn thêm DIRECT_UPLOAD_TTL
+// (thời gian để client upload xong và gọi xác nhận), không chờ IMAGE_GC_GRACE như ảnh.
+func purgeExpiredUploads(dryRun bool) (int, error) {
+	uploads, err := firestore.List[models.ImageUpload](imageUploadCollection)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-config.DirectUploadTTL)
+	n := 0
+	for _, u := range uploads {
+		if u.ExpiresAt.After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			discardUpload(&u)
+		}
+		n++
+	}
+	return n, nil
+}
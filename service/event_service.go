@@ -0,0 +1,131 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+)
+
+// Loại thay đổi phát trên change feed
+const (
+	ChangeCreated  = "created"
+	ChangeUpdated  = "updated"
+	ChangeDeleted  = "deleted"
+	ChangeRestored = "restored"
+)
+
+// Số event tối đa chờ gửi cho một subscriber, subscriber đọc chậm hơn sẽ bị ngắt để tự kết nối lại
+const subscriberBuffer = 64
+
+// ChangeEvent là một thay đổi của database hoặc piece, phát cho các client đang nghe change feed
+type ChangeEvent struct {
+	// ID có dạng "<epoch>-<seq>", epoch đổi mỗi lần server khởi động
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Collection string      `json:"collection"`
+	DocID      string      `json:"doc_id"`
+	DatabaseID string      `json:"database_id"`
+	Data       interface{} `json:"data,omitempty"`
+	At         time.Time   `json:"at"`
+}
+
+// Subscription nhận các event khớp bộ lọc. C bị đóng khi subscriber đọc không kịp.
+type Subscription struct {
+	C          <-chan ChangeEvent
+	ch         chan ChangeEvent
+	databaseID string
+}
+
+// changeBus giữ các subscriber và vòng đệm các event gần nhất để client kết nối lại đọc tiếp
+var changeBus = struct {
+	sync.Mutex
+	epoch  string
+	seq    uint64
+	recent []ChangeEvent // vòng đệm, phần tử thứ seq nằm ở vị trí (seq-1) % len
+	subs   map[*Subscription]struct{}
+}{
+	epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	recent: make([]ChangeEvent, max(config.ChangeFeedBuffer, 1)),
+	subs:   make(map[*Subscription]struct{}),
+}
+
+// PublishChange phát thay đổi cho các subscriber, bỏ offline bundle đã cache và đánh dấu index ảnh tương tự cần cập nhật.
+// databaseID là database chứa document (với database là chính nó), dùng để lọc.
+func PublishChange(changeType, collection, docID, databaseID string, data interface{}) {
+	InvalidateOfflineBundle()
+	invalidateSimilarityIndex(collection, docID)
+
+	changeBus.Lock()
+	defer changeBus.Unlock()
+
+	changeBus.seq++
+	event := ChangeEvent{
+		ID:         changeBus.epoch + "-" + strconv.FormatUint(changeBus.seq, 10),
+		Type:       changeType,
+		Collection: collection,
+		DocID:      docID,
+		DatabaseID: databaseID,
+		Data:       data,
+		At:         time.Now().UTC(),
+	}
+	changeBus.recent[(changeBus.seq-1)%uint64(len(changeBus.recent))] = event
+
+	for sub := range changeBus.subs {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// Không chặn người ghi vì một client chậm, client sẽ kết nối lại với Last-Event-ID
+			delete(changeBus.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// SubscribeChanges đăng ký nhận event, databaseID rỗng là nhận tất cả.
+// Với lastEventID khác rỗng, trả thêm các event sau nó còn trong vòng đệm; complete = false nghĩa là
+// không thể nối tiếp (server đã khởi động lại hoặc event đã trôi khỏi vòng đệm), client phải tải lại dữ liệu.
+func SubscribeChanges(databaseID, lastEventID string) (sub *Subscription, missed []ChangeEvent, complete bool) {
+	ch := make(chan ChangeEvent, subscriberBuffer)
+	sub = &Subscription{C: ch, ch: ch, databaseID: databaseID}
+
+	changeBus.Lock()
+	defer changeBus.Unlock()
+	changeBus.subs[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+
+	epoch, seqStr, _ := strings.Cut(lastEventID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	size := uint64(len(changeBus.recent))
+	if err != nil || epoch != changeBus.epoch || seq > changeBus.seq || changeBus.seq-seq > size {
+		return sub, nil, false
+	}
+	for s := seq + 1; s <= changeBus.seq; s++ {
+		if event := changeBus.recent[(s-1)%size]; sub.matches(event) {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed, true
+}
+
+// Unsubscribe hủy đăng ký, gọi được nhiều lần
+func (s *Subscription) Unsubscribe() {
+	changeBus.Lock()
+	defer changeBus.Unlock()
+	if _, ok := changeBus.subs[s]; ok {
+		delete(changeBus.subs, s)
+		close(s.ch)
+	}
+}
+
+func (s *Subscription) matches(event ChangeEvent) bool {
+	return s.databaseID == "" || s.databaseID == event.DatabaseID
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"backend/models"
+)
+
+type resizeMode int
+
+const (
+	resizeFill      resizeMode = iota // cắt giữa rồi thu về đúng size x size
+	resizeFit                         // thu nhỏ để cạnh dài bằng size, không phóng to
+	resizeLetterbox                   // thu vào khung size x size, phần thừa tô màu nền
+)
+
+// derivativeSpec mô tả một bản dẫn xuất sinh khi upload
+type derivativeSpec struct {
+	name    string
+	size    int
+	mode    resizeMode
+	quality int
+}
+
+var imageDerivatives = []derivativeSpec{
+	{models.VariantThumbnail, 256, resizeFill, 80},
+	{models.VariantMedium, 1024, resizeFit, 85},
+	{models.VariantTraining, 640, resizeLetterbox, 92},
+}
+
+// Màu viền letterbox giống lúc train YOLO
+var letterboxColor = color.RGBA{114, 114, 114, 255}
+
+// encodedImage là một bản dẫn xuất đã mã hóa JPEG
+type encodedImage struct {
+	data          []byte
+	width, height int
+}
+
+// buildDerivatives sinh các bản dẫn xuất dạng JPEG từ ảnh gốc đã giải mã
+func buildDerivatives(src image.Image) (map[string]encodedImage, error) {
+	out := make(map[string]encodedImage, len(imageDerivatives))
+	for _, spec := range imageDerivatives {
+		img := resizeImage(src, spec.size, spec.mode)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: spec.quality}); err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		out[spec.name] = encodedImage{data: buf.Bytes(), width: b.Dx(), height: b.Dy()}
+	}
+	return out, nil
+}
+
+func resizeImage(src image.Image, size int, mode resizeMode) image.Image {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	switch mode {
+	case resizeFill:
+		side := min(sw, sh)
+		crop := image.Rect(0, 0, side, side).Add(sb.Min).Add(image.Pt((sw-side)/2, (sh-side)/2))
+		size = min(size, side)
+		dst := newCanvas(size, size, color.White)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+		return dst
+
+	case resizeLetterbox:
+		w, h := fitWithin(sw, sh, size)
+		dst := newCanvas(size, size, letterboxColor)
+		offset := image.Pt((size-w)/2, (size-h)/2)
+		draw.CatmullRom.Scale(dst, image.Rect(0, 0, w, h).Add(offset), src, sb, draw.Over, nil)
+		return dst
+
+	default:
+		w, h := sw, sh
+		if max(sw, sh) > size {
+			w, h = fitWithin(sw, sh, size)
+		}
+		dst := newCanvas(w, h, color.White)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, sb, draw.Over, nil)
+		return dst
+	}
+}
+
+// fitWithin trả về kích thước giữ tỉ lệ với cạnh dài bằng size
+func fitWithin(w, h, size int) (int, int) {
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+// newCanvas tạo ảnh nền màu bg, ảnh trong suốt (PNG) được vẽ đè lên nền này vì JPEG không có alpha
+func newCanvas(w, h int, bg color.Color) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	return dst
+}
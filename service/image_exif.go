@@ -0,0 +1,191 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// Tag EXIF cần đọc
+const (
+	exifTagMake             = 0x010f
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+	exifTagOffsetTimeOrig   = 0x9011
+	gpsTagLatitudeRef       = 0x0001
+	gpsTagLatitude          = 0x0002
+	gpsTagLongitudeRef      = 0x0003
+	gpsTagLongitude         = 0x0004
+)
+
+// exifData là các thông tin đọc được từ khối EXIF (TIFF)
+type exifData struct {
+	orientation int
+	make, model string
+	capturedAt  *time.Time
+	hasGPS      bool
+	lat, lng    float64
+}
+
+var errBadExif = errors.New("malformed exif")
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // giá trị thô (đã lấy từ offset nếu dài hơn 4 byte)
+}
+
+// parseExif đọc khối TIFF của EXIF. Các tag lỗi được bỏ qua, chỉ header hỏng mới trả lỗi.
+func parseExif(data []byte) (*exifData, error) {
+	// Một số file để nguyên tiền tố "Exif\0\0" trước header TIFF
+	if len(data) >= 6 && string(data[:6]) == "Exif\x00\x00" {
+		data = data[6:]
+	}
+	if len(data) < 8 {
+		return nil, errBadExif
+	}
+	r := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, errBadExif
+	}
+	if r.order.Uint16(data[2:4]) != 42 {
+		return nil, errBadExif
+	}
+
+	ifd0 := r.readIFD(r.order.Uint32(data[4:8]))
+	out := &exifData{orientation: 1}
+	if e, ok := ifd0[exifTagOrientation]; ok {
+		if v := r.uint(e); v >= 1 && v <= 8 {
+			out.orientation = int(v)
+		}
+	}
+	out.make = r.ascii(ifd0[exifTagMake])
+	out.model = r.ascii(ifd0[exifTagModel])
+
+	if e, ok := ifd0[exifTagExifIFD]; ok {
+		sub := r.readIFD(uint32(r.uint(e)))
+		out.capturedAt = exifTime(r.ascii(sub[exifTagDateTimeOriginal]), r.ascii(sub[exifTagOffsetTimeOrig]))
+	}
+	if e, ok := ifd0[exifTagGPSIFD]; ok {
+		gps := r.readIFD(uint32(r.uint(e)))
+		lat, okLat := r.degrees(gps[gpsTagLatitude])
+		lng, okLng := r.degrees(gps[gpsTagLongitude])
+		if okLat && okLng {
+			if strings.HasPrefix(r.ascii(gps[gpsTagLatitudeRef]), "S") {
+				lat = -lat
+			}
+			if strings.HasPrefix(r.ascii(gps[gpsTagLongitudeRef]), "W") {
+				lng = -lng
+			}
+			out.hasGPS, out.lat, out.lng = true, lat, lng
+		}
+	}
+	return out, nil
+}
+
+// Kích thước (byte) của từng kiểu dữ liệu TIFF
+var tiffTypeSize = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+func (r *tiffReader) readIFD(off uint32) map[uint16]ifdEntry {
+	entries := make(map[uint16]ifdEntry)
+	if uint64(off)+2 > uint64(len(r.data)) {
+		return entries
+	}
+	n := uint32(r.order.Uint16(r.data[off:]))
+	for i := uint32(0); i < n; i++ {
+		p := uint64(off) + 2 + uint64(i)*12
+		if p+12 > uint64(len(r.data)) {
+			break
+		}
+		e := r.data[p : p+12]
+		typ, count := r.order.Uint16(e[2:4]), r.order.Uint32(e[4:8])
+		size := uint64(tiffTypeSize[typ]) * uint64(count)
+		if size == 0 {
+			continue
+		}
+		value := e[8:12]
+		if size > 4 {
+			voff := uint64(r.order.Uint32(e[8:12]))
+			if voff+size > uint64(len(r.data)) {
+				continue
+			}
+			value = r.data[voff : voff+size]
+		}
+		entries[r.order.Uint16(e[0:2])] = ifdEntry{typ: typ, count: count, value: value[:min(size, uint64(len(value)))]}
+	}
+	return entries
+}
+
+func (r *tiffReader) uint(e ifdEntry) uint32 {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(r.order.Uint16(e.value))
+	case (e.typ == 4 || e.typ == 9) && len(e.value) >= 4:
+		return r.order.Uint32(e.value)
+	}
+	return 0
+}
+
+func (r *tiffReader) ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// degrees đổi ba giá trị rational độ/phút/giây thành độ thập phân
+func (r *tiffReader) degrees(e ifdEntry) (float64, bool) {
+	if e.typ != 5 || e.count < 3 || len(e.value) < 24 {
+		return 0, false
+	}
+	var parts [3]float64
+	for i := range parts {
+		num := r.order.Uint32(e.value[i*8:])
+		den := r.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	v := parts[0] + parts[1]/60 + parts[2]/3600
+	if math.IsNaN(v) || v > 180 {
+		return 0, false
+	}
+	return v, true
+}
+
+// exifTime đọc DateTimeOriginal ("2006:01:02 15:04:05"), kèm múi giờ nếu có OffsetTimeOriginal.
+// Không có múi giờ thì coi như UTC vì EXIF lưu giờ địa phương không kèm múi.
+func exifTime(value, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	loc := time.UTC
+	if offset != "" {
+		if t, err := time.Parse("-07:00", offset); err == nil {
+			loc = t.Location()
+		}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, loc)
+	if err != nil || t.Year() < 1900 {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"backend/firestore"
+	"backend/models"
+)
+
+// OrphanImage là ảnh không còn piece/database nào dùng
+type OrphanImage struct {
+	ImageID           string    `json:"image_id"`
+	URL               string    `json:"url"`
+	Bytes             int64     `json:"bytes"`
+	UnreferencedSince time.Time `json:"unreferenced_since"`
+	DeleteAfter       time.Time `json:"delete_after"`
+	// Đã quá thời gian chờ: bị xóa ở lần chạy này (hoặc sẽ bị xóa nếu dry run)
+	Expired bool `json:"expired"`
+}
+
+// ImageGCReport tổng kết một lần chạy GC ảnh
+type ImageGCReport struct {
+	DryRun     bool          `json:"dry_run"`
+	Scanned    int           `json:"scanned"`
+	Referenced int           `json:"referenced"`
+	Orphans    []OrphanImage `json:"orphans"`
+	Deleted    int           `json:"deleted"`
+	FreedBytes int64         `json:"freed_bytes"`
+	// Lượt upload trực tiếp hết hạn mà không được xác nhận, file tạm bị xóa
+	ExpiredUploads int `json:"expired_uploads"`
+}
+
+// imageGCMu tránh hai lần GC chạy chồng nhau (job định kỳ và lệnh chạy tay)
+var imageGCMu sync.Mutex
+
+// imageOwners gom URL ảnh -> các owner đang dùng, tính cả item trong thùng rác vì có thể được khôi phục
+func imageOwners() (map[string][]string, error) {
+	dbs, err := firestore.List[models.WoodDatabase]("wood_database")
+	if err != nil {
+		return nil, err
+	}
+	pieces, err := firestore.List[models.WoodPiece]("wood_piece")
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string][]string)
+	for _, db := range dbs {
+		if db.Image != "" {
+			owners[db.Image] = append(owners[db.Image], "wood_database/"+db.ID)
+		}
+	}
+	for _, p := range pieces {
+		for _, url := range p.ImageUrls {
+			owner := "wood_piece/" + p.ID
+			if !slices.Contains(owners[url], owner) {
+				owners[url] = append(owners[url], owner)
+			}
+		}
+	}
+	for _, list := range owners {
+		slices.Sort(list)
+	}
+	return owners, nil
+}
+
+// CollectOrphanImages đối chiếu image_asset với URL ảnh đang được dùng: cập nhật owners, đánh dấu
+// unreferenced_since cho ảnh mới mất owner và xóa ảnh (kèm bản dẫn xuất) không có owner lâu hơn grace.
+// Lượt upload trực tiếp hết hạn lâu hơn grace cũng bị dọn. dryRun chỉ báo cáo, không ghi gì.
+// Ảnh upload trước khi có image_asset không được quản lý.
+func CollectOrphanImages(grace time.Duration, dryRun bool) (*ImageGCReport, error) {
+	imageGCMu.Lock()
+	defer imageGCMu.Unlock()
+
+	assets, err := firestore.List[models.ImageAsset](imageAssetCollection)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := imageOwners()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	report := &ImageGCReport{DryRun: dryRun, Scanned: len(assets), Orphans: []OrphanImage{}}
+	if report.ExpiredUploads, err = purgeExpiredUploads(dryRun); err != nil {
+		return report, err
+	}
+	for _, a := range assets {
+		current := owners[a.URL]
+		if len(current) > 0 {
+			report.Referenced++
+			if !dryRun && (a.UnreferencedSince != nil || !slices.Equal(a.Owners, current)) {
+				if err := firestore.UpdateFields(imageAssetCollection, a.ID, map[string]interface{}{
+					"owners":             current,
+					"unreferenced_since": nil,
+				}); err != nil {
+					return report, err
+				}
+			}
+			continue
+		}
+
+		since := now
+		if a.UnreferencedSince != nil {
+			since = *a.UnreferencedSince
+		} else if !dryRun {
+			if err := firestore.UpdateFields(imageAssetCollection, a.ID, map[string]interface{}{
+				"owners":             []string{},
+				"unreferenced_since": now,
+			}); err != nil {
+				return report, err
+			}
+		}
+
+		orphan := OrphanImage{
+			ImageID:           a.ID,
+			URL:               a.URL,
+			Bytes:             assetBytes(&a),
+			UnreferencedSince: since,
+			DeleteAfter:       since.Add(grace),
+			Expired:           !now.Before(since.Add(grace)),
+		}
+		report.Orphans = append(report.Orphans, orphan)
+		if !orphan.Expired || dryRun {
+			continue
+		}
+
+		if err := deleteVariants(&a); err != nil {
+			log.Printf("image gc: cannot delete blobs of %s: %v", a.ID, err)
+			continue
+		}
+		if err := firestore.DeleteDocument(imageAssetCollection, a.ID); err != nil {
+			return report, err
+		}
+		unindexImageHash(&a)
+		report.Deleted++
+		report.FreedBytes += orphan.Bytes
+	}
+	return report, nil
+}
+
+// releaseImages bỏ owner khỏi các ảnh urls thay vì xóa ngay: ảnh không còn owner được đánh dấu
+// unreferenced_since và để GC xóa sau IMAGE_GC_GRACE. GC tính lại owner từ dữ liệu trước khi xóa nên ảnh
+// còn được document khác dùng (import, PUT/PATCH, ảnh bìa) không bị mất. URL không có image_asset được bỏ qua,
+// owner rỗng chỉ đánh dấu các ảnh chưa có owner nào.
+// Trả về số ảnh không còn owner.
+func releaseImages(owner string, urls []string) (released int) {
+	now := time.Now().UTC()
+	seen := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+
+		asset, err := GetImageAssetByURL(url)
+		if errors.Is(err, firestore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("cannot release image %s: %v", url, err)
+			continue
+		}
+		owners := slices.DeleteFunc(slices.Clone(asset.Owners), func(o string) bool { return o == owner })
+		if owners == nil {
+			owners = []string{}
+		}
+		fields := map[string]interface{}{"owners": owners}
+		if len(owners) == 0 {
+			released++
+			if asset.UnreferencedSince == nil {
+				fields["unreferenced_since"] = now
+			}
+		}
+		if err := firestore.UpdateFields(imageAssetCollection, asset.ID, fields); err != nil {
+			log.Printf("cannot release image %s: %v", url, err)
+		}
+	}
+	return released
+}
+
+// assetBytes là tổng dung lượng mọi bản của ảnh
+func assetBytes(a *models.ImageAsset) int64 {
+	var n int64
+	for _, v := range a.Variants {
+		n += v.Bytes
+	}
+	return n
+}
+
+// StartImageGC chạy CollectOrphanImages định kỳ trong background, interval 0 để tắt
+func StartImageGC(grace, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			report, err := CollectOrphanImages(grace, false)
+			if err != nil {
+				log.Println("Image GC failed:", err)
+				continue
+			}
+			if report.ExpiredUploads > 0 {
+				log.Printf("Image GC: removed %d expired direct uploads", report.ExpiredUploads)
+			}
+			if report.Deleted > 0 {
+				log.Printf("Image GC: deleted %d orphaned images (%d bytes), %d still waiting",
+					report.Deleted, report.FreedBytes, len(report.Orphans)-report.Deleted)
+			}
+		}
+	}()
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math/bits"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+	"backend/storage"
+)
+
+// Chính sách với ảnh gần trùng khi upload (IMAGE_DUPLICATE_POLICY)
+const (
+	DuplicatePolicyOff    = "off"    // không so sánh
+	DuplicatePolicyWarn   = "warn"   // vẫn lưu, trả danh sách ảnh trùng trong duplicates
+	DuplicatePolicyReject = "reject" // từ chối với 409 duplicate_image
+)
+
+// dHash tính difference hash 64 bit: thu ảnh về 9x8 xám, mỗi bit cho biết điểm ảnh có sáng hơn điểm bên phải không.
+// Ảnh giống nhau sau khi nén lại, đổi kích thước hay chỉnh sáng nhẹ có hash chênh nhau ít bit.
+func dHash(src image.Image) uint64 {
+	gray := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.CatmullRom.Scale(gray, gray.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+func formatPHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+func parsePHash(s string) (uint64, bool) {
+	h, err := strconv.ParseUint(s, 16, 64)
+	return h, err == nil && len(s) == 16
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// ---- Index hash trong bộ nhớ ----
+
+// Thời gian dùng lại index trước khi đọc lại image_asset (để thấy ảnh do instance khác upload)
+const imageHashIndexTTL = 5 * time.Minute
+
+type hashEntry struct {
+	id, url string
+	hash    uint64
+}
+
+// hashIndex giữ hash của mọi ảnh để kiểm tra trùng khi upload mà không phải đọc cả collection mỗi lần
+var hashIndex struct {
+	sync.Mutex
+	entries  []hashEntry
+	loadedAt time.Time
+}
+
+func hashEntries(assets []models.ImageAsset) []hashEntry {
+	entries := make([]hashEntry, 0, len(assets))
+	for _, a := range assets {
+		if h, ok := parsePHash(a.PHash); ok {
+			entries = append(entries, hashEntry{id: a.ID, url: a.URL, hash: h})
+		}
+	}
+	return entries
+}
+
+// findNearDuplicates trả các ảnh có hash cách hash không quá maxDistance bit, gần nhất trước
+func findNearDuplicates(hash uint64, maxDistance int) ([]models.ImageDuplicate, error) {
+	hashIndex.Lock()
+	defer hashIndex.Unlock()
+	if hashIndex.entries == nil || time.Since(hashIndex.loadedAt) > imageHashIndexTTL {
+		assets, err := firestore.List[models.ImageAsset](imageAssetCollection)
+		if err != nil {
+			return nil, err
+		}
+		hashIndex.entries, hashIndex.loadedAt = hashEntries(assets), time.Now()
+	}
+
+	var matches []models.ImageDuplicate
+	for _, e := range hashIndex.entries {
+		if d := hammingDistance(hash, e.hash); d <= maxDistance {
+			matches = append(matches, models.ImageDuplicate{ImageID: e.id, URL: e.url, Distance: d})
+		}
+	}
+	slices.SortFunc(matches, func(a, b models.ImageDuplicate) int { return a.Distance - b.Distance })
+	return matches, nil
+}
+
+// indexImageHash thêm (hoặc cập nhật) ảnh vừa lưu vào index hash và báo index tìm ảnh tương tự đọc lại ảnh đó
+func indexImageHash(asset *models.ImageAsset) {
+	invalidateSimilarityImage(asset.URL)
+	h, ok := parsePHash(asset.PHash)
+	if !ok {
+		return
+	}
+	hashIndex.Lock()
+	if hashIndex.entries != nil {
+		hashIndex.entries = slices.DeleteFunc(hashIndex.entries, func(e hashEntry) bool { return e.id == asset.ID })
+		hashIndex.entries = append(hashIndex.entries, hashEntry{id: asset.ID, url: asset.URL, hash: h})
+	}
+	hashIndex.Unlock()
+}
+
+// unindexImageHash bỏ ảnh đã xóa khỏi index hash và index tìm ảnh tương tự
+func unindexImageHash(asset *models.ImageAsset) {
+	invalidateSimilarityImage(asset.URL)
+	hashIndex.Lock()
+	hashIndex.entries = slices.DeleteFunc(hashIndex.entries, func(e hashEntry) bool { return e.id == asset.ID })
+	hashIndex.Unlock()
+}
+
+// duplicateError là lỗi 409 khi ảnh upload gần trùng ảnh đã có, mỗi ảnh trùng là một detail
+func duplicateError(matches []models.ImageDuplicate) *apperr.Error {
+	err := apperr.New(http.StatusConflict, "duplicate_image", "Image is a near-duplicate of an existing image")
+	for _, m := range matches {
+		err.Details = append(err.Details, apperr.FieldError{
+			Field:   "file",
+			Code:    "duplicate",
+			Message: fmt.Sprintf("%s (distance %d)", m.URL, m.Distance),
+		})
+	}
+	return err
+}
+
+// checkDuplicates áp dụng IMAGE_DUPLICATE_POLICY cho ảnh sắp lưu: trả lỗi khi phải từ chối,
+// ngược lại trả danh sách ảnh trùng để báo cho client
+func checkDuplicates(hash uint64, allow bool) ([]models.ImageDuplicate, error) {
+	if config.ImageDuplicatePolicy == DuplicatePolicyOff {
+		return nil, nil
+	}
+	matches, err := findNearDuplicates(hash, config.ImageDuplicateDistance)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > 0 && config.ImageDuplicatePolicy == DuplicatePolicyReject && !allow {
+		return nil, duplicateError(matches)
+	}
+	return matches, nil
+}
+
+// ---- Báo cáo ----
+
+// DuplicateImage là một ảnh trong cụm trùng kèm các piece/database đang dùng ảnh đó
+type DuplicateImage struct {
+	ImageID     string   `json:"image_id"`
+	URL         string   `json:"url"`
+	Thumbnail   string   `json:"thumbnail,omitempty"`
+	PieceIDs    []string `json:"piece_ids"`
+	DatabaseIDs []string `json:"database_ids"`
+}
+
+// DuplicateCluster là nhóm ảnh nối với nhau bởi các cặp cách nhau không quá distance bit
+type DuplicateCluster struct {
+	Images      []DuplicateImage `json:"images"`
+	MaxDistance int              `json:"max_distance"`
+	// Ảnh của cụm thuộc nhiều piece khác nhau, có thể rơi vào cả tập train và test
+	CrossPiece bool `json:"cross_piece"`
+}
+
+// DuplicateImageClusters gom các ảnh gần trùng trong toàn thư viện thành cụm (so sánh mọi cặp, O(n²) phép XOR).
+// Cụm lớn nhất đứng trước. Ảnh chưa có phash (upload trước khi có hash) bị bỏ qua.
+func DuplicateImageClusters(maxDistance int) ([]DuplicateCluster, error) {
+	assets, err := firestore.List[models.ImageAsset](imageAssetCollection)
+	if err != nil {
+		return nil, err
+	}
+	pieces, err := firestore.List[models.WoodPiece]("wood_piece")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := hashEntries(assets)
+
+	// Union-find trên chỉ số entry
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if hammingDistance(entries[i].hash, entries[j].hash) <= maxDistance {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+	groups := make(map[int][]int)
+	for i := range entries {
+		r := find(i)
+		groups[r] = append(groups[r], i)
+	}
+
+	thumbnails := make(map[string]string, len(assets))
+	for _, a := range assets {
+		if v, ok := a.Variants[models.VariantThumbnail]; ok {
+			thumbnails[a.URL] = v.URL
+		}
+	}
+	type usage struct{ pieces, databases []string }
+	usedBy := make(map[string]*usage)
+	for _, p := range pieces {
+		if p.DeletedAt != nil {
+			continue
+		}
+		for _, url := range p.ImageUrls {
+			u := usedBy[url]
+			if u == nil {
+				u = &usage{}
+				usedBy[url] = u
+			}
+			u.pieces = append(u.pieces, p.ID)
+			if !slices.Contains(u.databases, p.DatabaseID) {
+				u.databases = append(u.databases, p.DatabaseID)
+			}
+		}
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		var cluster DuplicateCluster
+		pieceSet := make(map[string]bool)
+		for k, i := range members {
+			e := entries[i]
+			img := DuplicateImage{ImageID: e.id, URL: e.url, Thumbnail: thumbnails[e.url], PieceIDs: []string{}, DatabaseIDs: []string{}}
+			if u := usedBy[e.url]; u != nil {
+				img.PieceIDs, img.DatabaseIDs = u.pieces, u.databases
+			}
+			for _, id := range img.PieceIDs {
+				pieceSet[id] = true
+			}
+			cluster.Images = append(cluster.Images, img)
+			for _, j := range members[k+1:] {
+				cluster.MaxDistance = max(cluster.MaxDistance, hammingDistance(e.hash, entries[j].hash))
+			}
+		}
+		cluster.CrossPiece = len(pieceSet) > 1
+		slices.SortFunc(cluster.Images, func(a, b DuplicateImage) int { return strings.Compare(a.ImageID, b.ImageID) })
+		clusters = append(clusters, cluster)
+	}
+	slices.SortFunc(clusters, func(a, b DuplicateCluster) int {
+		if len(a.Images) != len(b.Images) {
+			return len(b.Images) - len(a.Images)
+		}
+		return strings.Compare(a.Images[0].ImageID, b.Images[0].ImageID)
+	})
+	return clusters, nil
+}
+
+// BackfillImageHashes tính phash và descriptor tìm ảnh tương tự cho các ảnh upload trước khi có hai giá trị này
+// bằng cách tải lại ảnh gốc. Ảnh không giải mã được (HEIC) được bỏ qua.
+func BackfillImageHashes() (updated int, err error) {
+	assets, err := firestore.List[models.ImageAsset](imageAssetCollection)
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range assets {
+		original, ok := a.Variants[models.VariantOriginal]
+		if (a.PHash != "" && len(a.Descriptor) == descriptorSize) || !ok || original.ContentType == ImageHEIC {
+			continue
+		}
+		rc, _, err := storage.Default.Get(context.Background(), original.Key)
+		if err != nil {
+			return updated, fmt.Errorf("image %s: %w", a.ID, err)
+		}
+		src, _, err := image.Decode(rc)
+		rc.Close()
+		if err != nil {
+			log.Printf("image %s: cannot decode original: %v", a.ID, err)
+			continue
+		}
+		a.PHash = formatPHash(dHash(src))
+		a.Descriptor = imageDescriptor(src)
+		if err := firestore.SetDocument(imageAssetCollection, a.ID, a); err != nil {
+			return updated, err
+		}
+		indexImageHash(&a)
+		updated++
+	}
+	return updated, nil
+}
@@ -0,0 +1,470 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"backend/apperr"
+)
+
+// Chất lượng JPEG khi phải mã hóa lại ảnh để xoay
+const orientedJPEGQuality = 92
+
+// sanitizeImage xoay ảnh theo EXIF orientation và bỏ metadata riêng tư (EXIF, GPS, XMP, IPTC, comment)
+// trước khi lưu, trả về ảnh đã làm sạch, thông tin ảnh mới và EXIF đọc được (nil nếu không có).
+// JPEG/PNG không cần xoay chỉ bị cắt metadata, không mã hóa lại. WebP cần xoay được chuyển sang JPEG
+// vì không có bộ mã hóa WebP. HEIC không giải mã được nên chỉ xóa trắng khối Exif/XMP tại chỗ;
+// hướng ảnh HEIC nằm trong box irot mà trình xem tự áp dụng.
+func sanitizeImage(data []byte, info *ImageInfo) ([]byte, *ImageInfo, *exifData, error) {
+	var raw []byte
+	switch info.ContentType {
+	case ImageJPEG:
+		raw = jpegExif(data)
+	case ImagePNG:
+		raw = pngExif(data)
+	case ImageWebP:
+		raw = webpExif(data)
+	case ImageHEIC:
+		clean, raw := scrubHEIF(data)
+		meta, _ := parseExif(raw)
+		return clean, info, meta, nil
+	}
+
+	var meta *exifData
+	if raw != nil {
+		meta, _ = parseExif(raw)
+	}
+	if meta == nil || meta.orientation == 1 {
+		clean, err := stripMetadata(data, info.ContentType)
+		return clean, info, meta, err
+	}
+
+	// Cần xoay: giải mã, xoay và mã hóa lại (bộ mã hóa chuẩn không ghi metadata)
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, nil, ErrUnsupportedImage
+	}
+	img := applyOrientation(src, meta.orientation)
+
+	var buf bytes.Buffer
+	out := &ImageInfo{ContentType: ImageJPEG, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	if info.ContentType == ImagePNG {
+		out.ContentType = ImagePNG
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: orientedJPEGQuality})
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return buf.Bytes(), out, meta, nil
+}
+
+func stripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case ImageJPEG:
+		return stripJPEG(data)
+	case ImagePNG:
+		return stripPNG(data)
+	case ImageWebP:
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+// applyOrientation xoay/lật ảnh về hướng chuẩn theo giá trị EXIF orientation (2-8)
+func applyOrientation(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 {
+		w, h = h, w
+	}
+	rgba := image.NewRGBA(b.Sub(b.Min))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := b.Dx(), b.Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// (sx, sy) là điểm ảnh gốc hiện ra tại (x, y) sau khi xoay
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = sw-1-x, y
+			case 3:
+				sx, sy = sw-1-x, sh-1-y
+			case 4:
+				sx, sy = x, sh-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, sh-1-x
+			case 7:
+				sx, sy = sw-1-y, sh-1-x
+			case 8:
+				sx, sy = sw-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			i, j := dst.PixOffset(x, y), rgba.PixOffset(sx, sy)
+			copy(dst.Pix[i:i+4], rgba.Pix[j:j+4])
+		}
+	}
+	return dst
+}
+
+// errBadImage là lỗi khi cấu trúc file ảnh hỏng (chunk/segment bị cắt, sai độ dài)
+var errBadImage = apperr.BadRequest("Malformed image")
+
+// ---- JPEG ----
+
+// Marker JPEG được giữ lại trước SOS: APP0 (JFIF), APP2 (ICC profile), APP14 (Adobe, cần cho màu) và các
+// segment không phải APPn/COM. Các APPn khác (EXIF, XMP, IPTC...) và COM bị bỏ.
+func keepJPEGSegment(marker byte) bool {
+	switch {
+	case marker == 0xe0, marker == 0xe2, marker == 0xee:
+		return true
+	case marker >= 0xe0 && marker <= 0xef, marker == 0xfe:
+		return false
+	}
+	return true
+}
+
+// jpegSegments duyệt các segment trước SOS, trả về offset bắt đầu dữ liệu ảnh (SOS)
+func jpegSegments(data []byte, fn func(marker byte, segment []byte)) (int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 0, errBadImage
+	}
+	p := 2
+	for p+4 <= len(data) {
+		if data[p] != 0xff {
+			return 0, errBadImage
+		}
+		marker := data[p+1]
+		if marker == 0xff { // byte đệm
+			p++
+			continue
+		}
+		if marker == 0xda {
+			return p, nil
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			fn(marker, data[p:p+2])
+			p += 2
+			continue
+		}
+		end := p + 2 + int(binary.BigEndian.Uint16(data[p+2:p+4]))
+		if end > len(data) {
+			return 0, errBadImage
+		}
+		fn(marker, data[p:end])
+		p = end
+	}
+	return 0, errBadImage
+}
+
+func jpegExif(data []byte) []byte {
+	var raw []byte
+	jpegSegments(data, func(marker byte, seg []byte) {
+		if raw == nil && marker == 0xe1 && len(seg) > 10 && string(seg[4:10]) == "Exif\x00\x00" {
+			raw = seg[10:]
+		}
+	})
+	return raw
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	sos, err := jpegSegments(data, func(marker byte, seg []byte) {
+		if keepJPEGSegment(marker) {
+			out = append(out, seg...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, data[sos:]...), nil
+}
+
+// ---- PNG ----
+
+// Chunk PNG chứa metadata riêng tư: EXIF, text (có thể chứa XMP) và thời điểm sửa
+var pngPrivateChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func pngChunks(data []byte, fn func(typ string, chunk []byte)) error {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return errBadImage
+	}
+	for p := 8; p < len(data); {
+		if p+12 > len(data) {
+			return errBadImage
+		}
+		end := p + 12 + int(binary.BigEndian.Uint32(data[p:p+4]))
+		if end > len(data) || end < p {
+			return errBadImage
+		}
+		fn(string(data[p+4:p+8]), data[p:end])
+		p = end
+	}
+	return nil
+}
+
+func pngExif(data []byte) []byte {
+	var raw []byte
+	pngChunks(data, func(typ string, chunk []byte) {
+		if typ == "eXIf" && raw == nil {
+			raw = chunk[8 : len(chunk)-4]
+		}
+	})
+	return raw
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := append(make([]byte, 0, len(data)), data[:8]...)
+	err := pngChunks(data, func(typ string, chunk []byte) {
+		if !pngPrivateChunks[typ] {
+			out = append(out, chunk...)
+		}
+	})
+	return out, err
+}
+
+// ---- WebP ----
+
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func webpChunks(data []byte, fn func(fourcc string, chunk []byte)) error {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return errBadImage
+	}
+	for p := 12; p < len(data); {
+		if p+8 > len(data) {
+			return errBadImage
+		}
+		size := int(binary.LittleEndian.Uint32(data[p+4 : p+8]))
+		end := p + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			// Chunk cuối có thể thiếu byte đệm
+			if end-1 == len(data) && size%2 == 1 {
+				end = len(data)
+			} else {
+				return errBadImage
+			}
+		}
+		fourcc := string(data[p : p+4])
+		// VP8X có 10 byte dữ liệu (flags và kích thước canvas), stripWebP sửa byte flags
+		if fourcc == "VP8X" && end-p < 18 {
+			return errBadImage
+		}
+		fn(fourcc, data[p:end])
+		p = end
+	}
+	return nil
+}
+
+func webpExif(data []byte) []byte {
+	var raw []byte
+	webpChunks(data, func(fourcc string, chunk []byte) {
+		if fourcc == "EXIF" && raw == nil {
+			size := binary.LittleEndian.Uint32(chunk[4:8])
+			raw = chunk[8 : 8+size]
+		}
+	})
+	return raw
+}
+
+func stripWebP(data []byte) ([]byte, error) {
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	err := webpChunks(data, func(fourcc string, chunk []byte) {
+		switch fourcc {
+		case "EXIF", "XMP ":
+			return
+		case "VP8X":
+			chunk = append([]byte(nil), chunk...)
+			chunk[8] &^= webpFlagEXIF | webpFlagXMP
+		}
+		out = append(out, chunk...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// ---- HEIF ----
+
+// scrubHEIF trả về bản sao của ảnh HEIF với dữ liệu của item Exif và XMP bị ghi đè bằng 0
+// (cấu trúc file giữ nguyên nên ảnh vẫn hiển thị được), kèm khối Exif gốc để đọc metadata.
+func scrubHEIF(data []byte) (clean, exif []byte) {
+	meta := findBox(data, "meta")
+	if len(meta) < 4 {
+		return data, nil
+	}
+	meta = meta[4:]
+	private := heifPrivateItems(findBox(meta, "iinf"))
+	if len(private) == 0 {
+		return data, nil
+	}
+
+	clean = append([]byte(nil), data...)
+	for id, extents := range heifItemLocations(findBox(meta, "iloc")) {
+		kind, ok := private[id]
+		if !ok {
+			continue
+		}
+		for _, ext := range extents {
+			if ext[0] > uint64(len(clean)) || ext[1] > uint64(len(clean))-ext[0] {
+				continue
+			}
+			item := clean[ext[0] : ext[0]+ext[1]]
+			// Item Exif bắt đầu bằng 4 byte offset tới header TIFF
+			if kind == "Exif" && exif == nil && len(item) > 4 {
+				skip := uint64(binary.BigEndian.Uint32(item[:4])) + 4
+				if skip < uint64(len(item)) {
+					exif = append([]byte(nil), item[skip:]...)
+				}
+			}
+			clear(item)
+		}
+	}
+	return clean, exif
+}
+
+// heifPrivateItems đọc box iinf, trả về ID của các item Exif và XMP (item mime kiểu rdf+xml)
+func heifPrivateItems(iinf []byte) map[uint32]string {
+	items := make(map[uint32]string)
+	if len(iinf) < 6 {
+		return items
+	}
+	body := iinf[6:] // version, flags, entry_count (16 bit)
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return items
+		}
+		body = iinf[8:] // entry_count 32 bit
+	}
+	for infe := range boxes(body, "infe") {
+		if len(infe) < 4 || infe[0] < 2 {
+			continue
+		}
+		p := 4
+		var id uint32
+		if infe[0] == 2 {
+			if len(infe) < p+2 {
+				continue
+			}
+			id, p = uint32(binary.BigEndian.Uint16(infe[p:])), p+2
+		} else {
+			if len(infe) < p+4 {
+				continue
+			}
+			id, p = binary.BigEndian.Uint32(infe[p:]), p+4
+		}
+		if len(infe) < p+6 {
+			continue
+		}
+		itemType := string(infe[p+2 : p+6]) // bỏ qua item_protection_index
+		switch itemType {
+		case "Exif":
+			items[id] = "Exif"
+		case "mime":
+			// item_name\0 content_type\0
+			rest := infe[p+6:]
+			_, rest, _ = bytes.Cut(rest, []byte{0})
+			contentType, _, _ := bytes.Cut(rest, []byte{0})
+			if strings.Contains(string(contentType), "rdf+xml") || strings.Contains(string(contentType), "xmp") {
+				items[id] = "XMP"
+			}
+		}
+	}
+	return items
+}
+
+// heifItemLocations đọc box iloc, trả về các đoạn (offset tuyệt đối trong file, độ dài) của từng item.
+// Chỉ hỗ trợ construction_method 0 (dữ liệu nằm trong file).
+func heifItemLocations(iloc []byte) map[uint32][][2]uint64 {
+	locs := make(map[uint32][][2]uint64)
+	if len(iloc) < 8 {
+		return locs
+	}
+	version := iloc[0]
+	offsetSize, lengthSize := int(iloc[4]>>4), int(iloc[4]&0x0f)
+	baseOffsetSize, indexSize := int(iloc[5]>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0f)
+	}
+
+	p := 6
+	read := func(n int) (uint64, bool) {
+		if n == 0 {
+			return 0, true
+		}
+		if p+n > len(iloc) || n > 8 {
+			return 0, false
+		}
+		var v uint64
+		for _, b := range iloc[p : p+n] {
+			v = v<<8 | uint64(b)
+		}
+		p += n
+		return v, true
+	}
+
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count, ok := read(idSize)
+	if !ok {
+		return locs
+	}
+	for i := uint64(0); i < count; i++ {
+		id, ok := read(idSize)
+		if !ok {
+			return locs
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			v, ok := read(2)
+			if !ok {
+				return locs
+			}
+			method = v & 0x0f
+		}
+		if _, ok := read(2); !ok { // data_reference_index
+			return locs
+		}
+		base, ok := read(baseOffsetSize)
+		if !ok {
+			return locs
+		}
+		extents, ok := read(2)
+		if !ok {
+			return locs
+		}
+		for j := uint64(0); j < extents; j++ {
+			if _, ok := read(indexSize); !ok {
+				return locs
+			}
+			off, ok1 := read(offsetSize)
+			length, ok2 := read(lengthSize)
+			if !ok1 || !ok2 {
+				return locs
+			}
+			if method == 0 {
+				locs[uint32(id)] = append(locs[uint32(id)], [2]uint64{base + off, length})
+			}
+		}
+	}
+	return locs
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"testing"
+
+	"backend/apperr"
+)
+
+// webpFile ghép các chunk (fourcc + dữ liệu) thành file WebP, size của chunk lấy theo dữ liệu
+func webpFile(chunks ...[]byte) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, c := range chunks {
+		body.Write(c)
+	}
+	out := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(out[4:8], uint32(body.Len()))
+	return append(out, body.Bytes()...)
+}
+
+func webpChunk(fourcc string, data []byte) []byte {
+	c := append([]byte(fourcc), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(c[4:8], uint32(len(data)))
+	c = append(c, data...)
+	if len(data)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func TestStripWebPRejectsTruncatedVP8X(t *testing.T) {
+	cases := map[string][]byte{
+		"empty VP8X":      webpFile(webpChunk("VP8X", nil)),
+		"short VP8X":      webpFile(webpChunk("VP8X", []byte{webpFlagEXIF, 0})),
+		"short VP8X last": webpFile(webpChunk("VP8 ", make([]byte, 10)), webpChunk("VP8X", nil)),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := stripWebP(data)
+			var appErr *apperr.Error
+			if !errors.As(err, &appErr) || appErr.Status != http.StatusBadRequest {
+				t.Fatalf("err = %v, want 400 bad request", err)
+			}
+		})
+	}
+}
+
+func TestStripWebPRemovesMetadata(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagEXIF | webpFlagXMP | 0x10
+	image := webpChunk("VP8L", []byte{0x2f, 1, 2, 3, 4})
+	data := webpFile(webpChunk("VP8X", vp8x), image, webpChunk("EXIF", []byte("Exif")), webpChunk("XMP ", []byte("<x/>")))
+
+	got, err := stripWebP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantVP8X := append([]byte(nil), vp8x...)
+	wantVP8X[0] = 0x10
+	want := webpFile(webpChunk("VP8X", wantVP8X), image)
+	if !bytes.Equal(got, want) {
+		t.Errorf("stripWebP =\n% x\nwant\n% x", got, want)
+	}
+}
+
+func TestWebPDimensionsRejectsTruncatedVP8X(t *testing.T) {
+	// Đủ 30 byte nhưng chunk VP8X khai báo 2 byte
+	data := webpFile(webpChunk("VP8X", []byte{0, 0}), webpChunk("VP8 ", make([]byte, 10)))
+	if _, _, err := webpDimensions(data); err == nil {
+		t.Fatal("expected error for truncated VP8X chunk")
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+	"backend/storage"
+)
+
+// ThumbnailURL trả về URL ảnh thu nhỏ (cạnh size px, cắt vuông) nhờ transformation của Cloudinary.
+// URL không phải của Cloudinary được trả lại nguyên vẹn.
+func ThumbnailURL(url string, size int) string {
+	base, rest, ok := strings.Cut(url, "/image/upload/")
+	if !ok || !strings.Contains(base, "res.cloudinary.com") {
+		return url
+	}
+	return fmt.Sprintf("%s/image/upload/c_fill,w_%d,h_%d,q_auto,f_auto/%s", base, size, size, rest)
+}
+
+// Phần mở rộng của file ảnh lưu trên blob store theo định dạng nhận dạng được
+var imageExtensions = map[string]string{
+	ImageJPEG: ".jpg",
+	ImagePNG:  ".png",
+	ImageWebP: ".webp",
+	ImageHEIC: ".heic",
+}
+
+// imageAssetCollection lưu thông tin các ảnh đã upload (ImageAsset)
+const imageAssetCollection = "image_asset"
+
+// UploadOptions là tùy chọn khi upload ảnh
+type UploadOptions struct {
+	CreatedBy string
+	// Lưu tọa độ GPS (đã làm tròn theo IMAGE_LOCATION_DECIMALS) vào metadata của ảnh
+	RecordLocation bool
+	// Vẫn lưu ảnh gần trùng khi IMAGE_DUPLICATE_POLICY=reject
+	AllowDuplicate bool
+}
+
+// UploadImage kiểm tra ảnh (ReadImage), xoay theo EXIF và bỏ metadata riêng tư (sanitizeImage), lưu ảnh gốc
+// cùng các bản dẫn xuất vào blob store dưới images/ rồi ghi ImageAsset. Ảnh không hợp lệ bị từ chối trước
+// khi gửi đi. Nếu không sinh được bản dẫn xuất (vd HEIC) thì chỉ lưu ảnh gốc.
+func UploadImage(ctx context.Context, file io.Reader, opts UploadOptions) (*models.ImageAsset, error) {
+	data, info, err := ReadImage(file)
+	if err != nil {
+		return nil, err
+	}
+	return storeImage(ctx, data, info, opts)
+}
+
+// storeImage làm sạch và lưu ảnh đã qua ReadImage, xem UploadImage
+func storeImage(ctx context.Context, data []byte, info *ImageInfo, opts UploadOptions) (*models.ImageAsset, error) {
+	data, info, exif, err := sanitizeImage(data, info)
+	if err != nil {
+		return nil, err
+	}
+
+	id := randomHex(16)
+	now := time.Now().UTC()
+	asset := &models.ImageAsset{
+		ID:        id,
+		Variants:  make(map[string]models.ImageVariant),
+		CreatedAt: now,
+		CreatedBy: opts.CreatedBy,
+		// Chưa gắn vào piece/database nào, GC xóa nếu vẫn vậy sau IMAGE_GC_GRACE
+		Owners:            []string{},
+		UnreferencedSince: &now,
+		Metadata:          imageMetadata(exif, opts.RecordLocation),
+	}
+	blobs := map[string]encodedImage{
+		models.VariantOriginal: {data: data, width: info.Width, height: info.Height},
+	}
+	if src, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		log.Printf("image %s: no derivatives for %s: %v", id, info.ContentType, err)
+	} else {
+		hash := dHash(src)
+		asset.PHash = formatPHash(hash)
+		asset.Descriptor = imageDescriptor(src)
+		if asset.Duplicates, err = checkDuplicates(hash, opts.AllowDuplicate); err != nil {
+			return nil, err
+		}
+		derivatives, err := buildDerivatives(src)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(blobs, derivatives)
+	}
+
+	for name, blob := range blobs {
+		key, contentType := "images/"+id+"_"+name+".jpg", ImageJPEG
+		if name == models.VariantOriginal {
+			key, contentType = "images/"+id+imageExtensions[info.ContentType], info.ContentType
+		}
+		obj, err := storage.Default.Put(ctx, key, bytes.NewReader(blob.data), storage.PutOptions{ContentType: contentType})
+		if err != nil {
+			deleteVariants(asset)
+			return nil, err
+		}
+		asset.Variants[name] = models.ImageVariant{
+			URL:         obj.URL,
+			Key:         key,
+			ContentType: contentType,
+			Width:       blob.width,
+			Height:      blob.height,
+			Bytes:       int64(len(blob.data)),
+		}
+	}
+	asset.URL = asset.Variants[models.VariantOriginal].URL
+
+	if err := firestore.CreateDocument(imageAssetCollection, id, asset); err != nil {
+		deleteVariants(asset)
+		return nil, err
+	}
+	indexImageHash(asset)
+	return asset, nil
+}
+
+// imageMetadata chọn các trường EXIF được phép lưu theo cấu hình, nil nếu không còn gì
+func imageMetadata(exif *exifData, recordLocation bool) *models.ImageMetadata {
+	if exif == nil {
+		return nil
+	}
+	meta := &models.ImageMetadata{}
+	if config.ImageCaptureMetadata {
+		meta.CapturedAt, meta.CameraMake, meta.CameraModel = exif.capturedAt, exif.make, exif.model
+	}
+	if recordLocation && exif.hasGPS {
+		scale := math.Pow10(max(config.ImageLocationDecimals, 0))
+		meta.Location = &models.ImageLocation{
+			Latitude:  math.Round(exif.lat*scale) / scale,
+			Longitude: math.Round(exif.lng*scale) / scale,
+		}
+	}
+	if *meta == (models.ImageMetadata{}) {
+		return nil
+	}
+	return meta
+}
+
+// GetImageAssetByURL tìm ImageAsset theo URL ảnh gốc. Ảnh upload trước khi có ImageAsset trả firestore.ErrNotFound.
+func GetImageAssetByURL(url string) (*models.ImageAsset, error) {
+	assets, err := firestore.GetDocumentsByField[models.ImageAsset](imageAssetCollection, "url", url)
+	if err != nil {
+		return nil, err
+	}
+	if len(assets) == 0 {
+		return nil, firestore.ErrNotFound
+	}
+	return &assets[0], nil
+}
+
+// DeleteImageByURL xóa ảnh trên blob store theo URL đã lưu, kèm các bản dẫn xuất và ImageAsset nếu có
+func DeleteImageByURL(url string) error {
+	asset, err := GetImageAssetByURL(url)
+	if err == nil {
+		if err := deleteVariants(asset); err != nil {
+			return err
+		}
+		unindexImageHash(asset)
+		return firestore.DeleteDocument(imageAssetCollection, asset.ID)
+	}
+	if !errors.Is(err, firestore.ErrNotFound) {
+		return err
+	}
+
+	key, ok := storage.Default.KeyFromURL(url)
+	if !ok {
+		return fmt.Errorf("not a stored image url: %s", url)
+	}
+	return storage.Default.Delete(context.Background(), key)
+}
+
+// deleteVariants xóa mọi bản của ảnh trên blob store, trả lỗi đầu tiên gặp phải
+func deleteVariants(asset *models.ImageAsset) error {
+	var firstErr error
+	for _, v := range asset.Variants {
+		if err := storage.Default.Delete(context.Background(), v.Key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// DeleteImagesByURL xóa nhiều ảnh, lỗi của từng ảnh chỉ được log lại để không chặn các ảnh còn lại
+func DeleteImagesByURL(urls []string) (deleted int) {
+	for _, url := range urls {
+		if err := DeleteImageByURL(url); err != nil {
+			log.Printf("cannot delete image %s: %v", url, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted
+}
@@ -0,0 +1,429 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+)
+
+// Descriptor gồm ba histogram, mỗi khối được chuẩn hóa rồi lấy căn (Hellinger) và nhân với căn trọng số,
+// nên tích vô hướng của hai descriptor là tổng có trọng số hệ số Bhattacharyya của từng khối, trong khoảng 0-1
+const (
+	descriptorSide = 64 // ảnh được thu về 64x64 trước khi tính
+
+	hueBins, satBins, valBins = 12, 3, 3
+	colorBins                 = hueBins * satBins * valBins
+	lbpBins                   = 10 // 9 mẫu LBP đồng nhất theo số bit 1, cộng 1 bin cho mẫu không đồng nhất
+	gradientBins              = 8  // hướng gradient 0-180°, cộng dồn theo độ lớn
+
+	descriptorSize = colorBins + lbpBins + gradientBins
+
+	colorWeight    = 0.5
+	lbpWeight      = 0.3
+	gradientWeight = 0.2
+)
+
+// Tám điểm lân cận của LBP theo chiều kim đồng hồ
+var lbpNeighbours = [8][2]int{{-1, -1}, {0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}}
+
+// imageDescriptor tính descriptor màu (histogram HSV) và texture (LBP, hướng gradient) của ảnh.
+// Không phụ thuộc kích thước ảnh; ảnh chụp cùng loại gỗ dưới ánh sáng tương tự cho điểm cao.
+func imageDescriptor(src image.Image) []float64 {
+	const n = descriptorSide
+	rgba := image.NewRGBA(image.Rect(0, 0, n, n))
+	draw.ApproxBiLinear.Scale(rgba, rgba.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	color := make([]float64, colorBins)
+	gray := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			c := rgba.RGBAAt(x, y)
+			r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+			h, s, v := hsv(r, g, b)
+			hb := min(int(h*hueBins), hueBins-1)
+			sb := min(int(s*satBins), satBins-1)
+			vb := min(int(v*valBins), valBins-1)
+			color[(hb*satBins+sb)*valBins+vb]++
+			gray[y*n+x] = 0.299*r + 0.587*g + 0.114*b
+		}
+	}
+
+	lbp := make([]float64, lbpBins)
+	gradient := make([]float64, gradientBins)
+	at := func(x, y int) float64 { return gray[y*n+x] }
+	for y := 1; y < n-1; y++ {
+		for x := 1; x < n-1; x++ {
+			var code uint8
+			for i, d := range lbpNeighbours {
+				if at(x+d[0], y+d[1]) >= at(x, y) {
+					code |= 1 << i
+				}
+			}
+			if bits.OnesCount8(code^bits.RotateLeft8(code, 1)) <= 2 {
+				lbp[bits.OnesCount8(code)]++
+			} else {
+				lbp[lbpBins-1]++
+			}
+
+			// Sobel
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			if mag := math.Hypot(gx, gy); mag > 0 {
+				angle := math.Atan2(gy, gx)
+				if angle < 0 {
+					angle += math.Pi
+				}
+				gradient[min(int(angle/math.Pi*gradientBins), gradientBins-1)] += mag
+			}
+		}
+	}
+
+	desc := make([]float64, 0, descriptorSize)
+	desc = appendHellinger(desc, color, colorWeight)
+	desc = appendHellinger(desc, lbp, lbpWeight)
+	desc = appendHellinger(desc, gradient, gradientWeight)
+	return desc
+}
+
+// appendHellinger chuẩn hóa hist về tổng weight rồi lấy căn từng bin; hist rỗng cho toàn số 0
+func appendHellinger(desc, hist []float64, weight float64) []float64 {
+	var sum float64
+	for _, v := range hist {
+		sum += v
+	}
+	for _, v := range hist {
+		if sum == 0 {
+			desc = append(desc, 0)
+			continue
+		}
+		desc = append(desc, math.Sqrt(weight*v/sum))
+	}
+	return desc
+}
+
+// hsv đổi màu RGB (0-1) sang HSV, h cũng trong khoảng 0-1
+func hsv(r, g, b float64) (h, s, v float64) {
+	hi, lo := max(r, g, b), min(r, g, b)
+	v = hi
+	if hi == 0 {
+		return 0, 0, v
+	}
+	s = (hi - lo) / hi
+	d := hi - lo
+	switch {
+	case d == 0:
+		h = 0
+	case hi == r:
+		h = math.Mod((g-b)/d, 6)
+	case hi == g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h /= 6
+	if h < 0 {
+		h++
+	}
+	return h, s, v
+}
+
+// descriptorSimilarity là độ giống nhau 0-1 của hai descriptor, 0 nếu khác phiên bản (độ dài)
+func descriptorSimilarity(a, b []float64) float64 {
+	if len(a) != descriptorSize || len(b) != descriptorSize {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
+
+// ---- Index trong bộ nhớ ----
+
+// Thời gian dùng lại index trước khi đọc lại toàn bộ (để thấy thay đổi từ instance khác)
+const similarityIndexTTL = 5 * time.Minute
+
+type similarityImage struct {
+	url, thumbnail string
+	descriptor     []float64
+}
+
+// similarityPiece là một piece đang hoạt động cùng các ảnh đã có descriptor
+type similarityPiece struct {
+	id, databaseID, name string
+	images               []similarityImage
+	// urls là mọi ảnh của piece, kể cả ảnh chưa có descriptor, để biết piece nào cần đọc lại khi ảnh thay đổi
+	urls []string
+}
+
+// similarityIndex giữ descriptor ảnh của mọi piece để tìm kiếm mà không phải đọc lại dữ liệu mỗi lần
+var similarityIndex struct {
+	sync.Mutex
+	pieces   map[string]*similarityPiece
+	loadedAt time.Time
+}
+
+// similarityStale ghi các piece và ảnh đã thay đổi kể từ lần tìm trước, tách khỏi lock của index để người ghi
+// không phải chờ lần tải lại index
+var similarityStale struct {
+	sync.Mutex
+	pieces map[string]bool
+	urls   map[string]bool
+	all    bool
+}
+
+// invalidateSimilarityIndex được gọi từ PublishChange: piece thay đổi thì chỉ đọc lại piece đó,
+// thay đổi database (xóa/khôi phục kèm piece) thì tải lại toàn bộ ở lần tìm tiếp theo
+func invalidateSimilarityIndex(collection, docID string) {
+	similarityStale.Lock()
+	defer similarityStale.Unlock()
+	if collection != "wood_piece" {
+		similarityStale.all = true
+		return
+	}
+	if similarityStale.pieces == nil {
+		similarityStale.pieces = make(map[string]bool)
+	}
+	similarityStale.pieces[docID] = true
+}
+
+// invalidateSimilarityImage được gọi khi ảnh được lưu, tính lại descriptor hoặc bị xóa (indexImageHash,
+// unindexImageHash): các piece đang dùng url được đọc lại ở lần tìm tiếp theo
+func invalidateSimilarityImage(url string) {
+	similarityStale.Lock()
+	defer similarityStale.Unlock()
+	if similarityStale.urls == nil {
+		similarityStale.urls = make(map[string]bool)
+	}
+	similarityStale.urls[url] = true
+}
+
+// refreshSimilarityIndex đưa index về trạng thái mới nhất, gọi khi đang giữ similarityIndex
+func refreshSimilarityIndex() error {
+	similarityStale.Lock()
+	stale, urls, all := similarityStale.pieces, similarityStale.urls, similarityStale.all
+	similarityStale.pieces, similarityStale.urls, similarityStale.all = nil, nil, false
+	similarityStale.Unlock()
+
+	if all || similarityIndex.pieces == nil || time.Since(similarityIndex.loadedAt) > similarityIndexTTL {
+		if err := loadSimilarityIndex(); err != nil {
+			similarityIndex.pieces = nil
+			return err
+		}
+		return nil
+	}
+	if len(urls) > 0 {
+		if stale == nil {
+			stale = make(map[string]bool)
+		}
+		for id, p := range similarityIndex.pieces {
+			if slices.ContainsFunc(p.urls, func(u string) bool { return urls[u] }) {
+				stale[id] = true
+			}
+		}
+	}
+	for id := range stale {
+		if err := reloadSimilarityPiece(id); err != nil {
+			// Lần tìm sau tải lại toàn bộ thay vì dùng index thiếu
+			similarityIndex.pieces = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func loadSimilarityIndex() error {
+	assets, err := firestore.List[models.ImageAsset](imageAssetCollection)
+	if err != nil {
+		return err
+	}
+	pieces, err := firestore.List[models.WoodPiece]("wood_piece")
+	if err != nil {
+		return err
+	}
+
+	byURL := make(map[string]*models.ImageAsset, len(assets))
+	for i := range assets {
+		byURL[assets[i].URL] = &assets[i]
+	}
+	index := make(map[string]*similarityPiece, len(pieces))
+	for i := range pieces {
+		if pieces[i].DeletedAt != nil {
+			continue
+		}
+		index[pieces[i].ID] = newSimilarityPiece(&pieces[i], func(url string) *models.ImageAsset { return byURL[url] })
+	}
+	similarityIndex.pieces, similarityIndex.loadedAt = index, time.Now()
+	return nil
+}
+
+// reloadSimilarityPiece đọc lại một piece và ảnh của nó, bỏ khỏi index nếu piece không còn hoạt động
+func reloadSimilarityPiece(id string) error {
+	piece, _, err := firestore.Get[models.WoodPiece]("wood_piece", id)
+	if errors.Is(err, firestore.ErrNotFound) || (err == nil && piece.DeletedAt != nil) {
+		delete(similarityIndex.pieces, id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var lookupErr error
+	entry := newSimilarityPiece(piece, func(url string) *models.ImageAsset {
+		asset, err := GetImageAssetByURL(url)
+		if err != nil && !errors.Is(err, firestore.ErrNotFound) {
+			lookupErr = err
+		}
+		return asset
+	})
+	if lookupErr != nil {
+		return lookupErr
+	}
+	similarityIndex.pieces[id] = entry
+	return nil
+}
+
+func newSimilarityPiece(piece *models.WoodPiece, asset func(url string) *models.ImageAsset) *similarityPiece {
+	entry := &similarityPiece{id: piece.ID, databaseID: piece.DatabaseID, name: piece.Name, urls: piece.ImageUrls}
+	for _, url := range piece.ImageUrls {
+		a := asset(url)
+		if a == nil || len(a.Descriptor) != descriptorSize {
+			continue
+		}
+		img := similarityImage{url: url, descriptor: a.Descriptor}
+		if v, ok := a.Variants[models.VariantThumbnail]; ok {
+			img.thumbnail = v.URL
+		}
+		entry.images = append(entry.images, img)
+	}
+	return entry
+}
+
+// ---- Tìm kiếm ----
+
+var (
+	// ErrImageNotSearchable trả về khi ảnh truy vấn không giải mã được để tính descriptor
+	ErrImageNotSearchable = apperr.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "Only JPEG, PNG and WebP images can be searched")
+	// ErrImageNotIndexed trả về khi ảnh trong thư viện chưa có descriptor
+	ErrImageNotIndexed = apperr.New(http.StatusUnprocessableEntity, "not_indexed", "Image has no similarity descriptor yet")
+)
+
+// SimilarQuery là điều kiện tìm ảnh tương tự
+type SimilarQuery struct {
+	// Số piece tối đa trả về, 0 là SIMILAR_SEARCH_LIMIT
+	Limit int
+	// Chỉ tìm trong database này, rỗng là toàn thư viện
+	DatabaseID string
+	// Bỏ các piece có ảnh này (ảnh truy vấn lấy từ thư viện)
+	ExcludeURL string
+}
+
+// SimilarPiece là một piece trong kết quả, điểm là độ giống của ảnh giống nhất của piece
+type SimilarPiece struct {
+	PieceID    string  `json:"piece_id"`
+	DatabaseID string  `json:"database_id"`
+	Name       string  `json:"name"`
+	Score      float64 `json:"score"` // 0-1, 1 là giống hệt
+	ImageURL   string  `json:"image_url"`
+	Thumbnail  string  `json:"thumbnail,omitempty"`
+}
+
+// SearchSimilarImage tìm các piece có ảnh giống ảnh r nhất. Ảnh được kiểm tra và xoay theo EXIF như khi upload,
+// nhưng không được lưu lại.
+func SearchSimilarImage(r io.Reader, q SimilarQuery) ([]SimilarPiece, error) {
+	data, info, err := ReadImage(r)
+	if err != nil {
+		return nil, err
+	}
+	data, _, _, err = sanitizeImage(data, info)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageNotSearchable
+	}
+	return searchSimilar(imageDescriptor(src), q)
+}
+
+// SearchSimilarToURL tìm các piece có ảnh giống ảnh url của thư viện, bỏ qua các piece đang chứa chính ảnh đó
+func SearchSimilarToURL(url string, q SimilarQuery) ([]SimilarPiece, error) {
+	asset, err := GetImageAssetByURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if len(asset.Descriptor) != descriptorSize {
+		return nil, ErrImageNotIndexed
+	}
+	q.ExcludeURL = url
+	return searchSimilar(asset.Descriptor, q)
+}
+
+func searchSimilar(desc []float64, q SimilarQuery) ([]SimilarPiece, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = config.SimilarSearchLimit
+	}
+	limit = min(limit, config.SimilarSearchMaxLimit)
+
+	similarityIndex.Lock()
+	defer similarityIndex.Unlock()
+	if err := refreshSimilarityIndex(); err != nil {
+		return nil, err
+	}
+
+	results := []SimilarPiece{}
+	for _, p := range similarityIndex.pieces {
+		if q.DatabaseID != "" && p.databaseID != q.DatabaseID {
+			continue
+		}
+		if q.ExcludeURL != "" && slices.ContainsFunc(p.images, func(img similarityImage) bool { return img.url == q.ExcludeURL }) {
+			continue
+		}
+		best := -1
+		var bestScore float64
+		for i, img := range p.images {
+			if score := descriptorSimilarity(desc, img.descriptor); best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		results = append(results, SimilarPiece{
+			PieceID:    p.id,
+			DatabaseID: p.databaseID,
+			Name:       p.name,
+			Score:      math.Round(bestScore*1e4) / 1e4,
+			ImageURL:   p.images[best].url,
+			Thumbnail:  p.images[best].thumbnail,
+		})
+	}
+	slices.SortFunc(results, func(a, b SimilarPiece) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.PieceID, b.PieceID)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"backend/apperr"
+	"backend/config"
+)
+
+// Định dạng ảnh được chấp nhận khi upload
+const (
+	ImageJPEG = "image/jpeg"
+	ImagePNG  = "image/png"
+	ImageWebP = "image/webp"
+	ImageHEIC = "image/heic"
+)
+
+var (
+	// ErrImageTooLarge trả về khi file ảnh vượt quá IMAGE_MAX_MB
+	ErrImageTooLarge = apperr.New(http.StatusRequestEntityTooLarge, "too_large", "Image exceeds the size limit")
+	// ErrImageDimensions trả về khi kích thước ảnh vượt giới hạn, chặn ảnh nhỏ nhưng giải nén ra rất lớn
+	ErrImageDimensions = apperr.New(http.StatusRequestEntityTooLarge, "too_large", "Image dimensions exceed the limit")
+	// ErrUnsupportedImage trả về khi nội dung file không phải JPEG, PNG, WebP hoặc HEIC hợp lệ
+	ErrUnsupportedImage = apperr.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "Only JPEG, PNG, WebP and HEIC images are accepted")
+)
+
+// ImageInfo là thông tin đọc được từ header của ảnh
+type ImageInfo struct {
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// ReadImage đọc toàn bộ ảnh (tối đa IMAGE_MAX_MB) và kiểm tra định dạng, kích thước theo nội dung file,
+// không tin vào tên file hay Content-Type client gửi lên
+func ReadImage(r io.Reader) ([]byte, *ImageInfo, error) {
+	data, err := io.ReadAll(io.LimitReader(r, config.ImageMaxBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > config.ImageMaxBytes {
+		return nil, nil, ErrImageTooLarge
+	}
+	info, err := InspectImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+// InspectImage nhận dạng ảnh theo magic bytes và kiểm tra kích thước (chỉ đọc header, không giải nén)
+func InspectImage(data []byte) (*ImageInfo, error) {
+	info := &ImageInfo{ContentType: sniffImageType(data)}
+
+	var err error
+	switch info.ContentType {
+	case ImageJPEG, ImagePNG:
+		var cfg image.Config
+		cfg, _, err = image.DecodeConfig(bytes.NewReader(data))
+		info.Width, info.Height = cfg.Width, cfg.Height
+	case ImageWebP:
+		info.Width, info.Height, err = webpDimensions(data)
+	case ImageHEIC:
+		info.Width, info.Height, err = heifDimensions(data)
+	default:
+		return nil, ErrUnsupportedImage
+	}
+	if err != nil || info.Width <= 0 || info.Height <= 0 {
+		return nil, ErrUnsupportedImage
+	}
+
+	if info.Width > config.ImageMaxDimension || info.Height > config.ImageMaxDimension ||
+		int64(info.Width)*int64(info.Height) > config.ImageMaxPixels {
+		return nil, ErrImageDimensions
+	}
+	return info, nil
+}
+
+// Brand của file HEIF (ftyp) được coi là HEIC
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true, "msf1": true,
+}
+
+func sniffImageType(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && heifBrands[string(data[8:12])] {
+		return ImageHEIC
+	}
+	switch ct := http.DetectContentType(data); ct {
+	case ImageJPEG, ImagePNG, ImageWebP:
+		return ct
+	}
+	return ""
+}
+
+// webpDimensions đọc kích thước từ chunk đầu tiên của file WebP (VP8, VP8L hoặc VP8X)
+func webpDimensions(data []byte) (int, int, error) {
+	if len(data) < 30 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	chunk := data[12:]
+	switch string(chunk[:4]) {
+	case "VP8 ":
+		// Frame header: 3 byte frame tag, 3 byte start code, rồi width/height 14 bit
+		if chunk[11] != 0x9d || chunk[12] != 0x01 || chunk[13] != 0x2a {
+			return 0, 0, fmt.Errorf("invalid VP8 start code")
+		}
+		w := int(binary.LittleEndian.Uint16(chunk[14:16]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(chunk[16:18]) & 0x3fff)
+		return w, h, nil
+	case "VP8L":
+		if chunk[8] != 0x2f {
+			return 0, 0, fmt.Errorf("invalid VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[9:13])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		if binary.LittleEndian.Uint32(chunk[4:8]) < 10 {
+			return 0, 0, fmt.Errorf("truncated VP8X chunk")
+		}
+		w := int(uint32(chunk[12]) | uint32(chunk[13])<<8 | uint32(chunk[14])<<16)
+		h := int(uint32(chunk[15]) | uint32(chunk[16])<<8 | uint32(chunk[17])<<16)
+		return w + 1, h + 1, nil
+	}
+	return 0, 0, fmt.Errorf("unknown WebP chunk %q", chunk[:4])
+}
+
+// heifDimensions đọc kích thước từ box ispe (meta/iprp/ipco/ispe) của file HEIF.
+// File có nhiều ảnh (tile, thumbnail) thì lấy kích thước lớn nhất.
+func heifDimensions(data []byte) (int, int, error) {
+	meta := findBox(data, "meta")
+	if len(meta) < 4 {
+		return 0, 0, fmt.Errorf("missing meta box")
+	}
+	// meta là full box: bỏ 4 byte version và flags
+	ipco := findBox(findBox(meta[4:], "iprp"), "ipco")
+
+	w, h := 0, 0
+	for body := range boxes(ipco, "ispe") {
+		if len(body) < 12 {
+			continue
+		}
+		bw := int(binary.BigEndian.Uint32(body[4:8]))
+		bh := int(binary.BigEndian.Uint32(body[8:12]))
+		if bw*bh > w*h {
+			w, h = bw, bh
+		}
+	}
+	if w == 0 {
+		return 0, 0, fmt.Errorf("missing ispe box")
+	}
+	return w, h, nil
+}
+
+// findBox trả về nội dung box ISO BMFF đầu tiên có kiểu typ ở cấp hiện tại
+func findBox(data []byte, typ string) []byte {
+	for body := range boxes(data, typ) {
+		return body
+	}
+	return nil
+}
+
+// boxes duyệt các box có kiểu typ ở cấp hiện tại, dừng khi gặp box hỏng
+func boxes(data []byte, typ string) func(yield func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(data) >= 8 {
+			size := uint64(binary.BigEndian.Uint32(data[:4]))
+			header := uint64(8)
+			switch size {
+			case 0:
+				size = uint64(len(data))
+			case 1:
+				if len(data) < 16 {
+					return
+				}
+				size, header = binary.BigEndian.Uint64(data[8:16]), 16
+			}
+			if size < header || size > uint64(len(data)) {
+				return
+			}
+			if string(data[4:8]) == typ && !yield(data[header:size]) {
+				return
+			}
+			data = data[size:]
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+	"backend/validation"
+)
+
+// Trạng thái của từng dòng trong báo cáo import
+const (
+	ImportValid   = "valid"   // hợp lệ (dry run, chưa ghi)
+	ImportCreated = "created" // đã ghi
+	ImportInvalid = "invalid" // không hợp lệ, xem errors
+	ImportFailed  = "failed"  // hợp lệ nhưng ghi lỗi, xem message
+)
+
+const (
+	importTypeDatabase = "database"
+	importTypePiece    = "piece"
+
+	// Số ảnh trong ZIP được tải lên cùng lúc
+	importUploadWorkers = 4
+	// Ảnh trong ZIP được kiểm tra URL bằng giá trị giữ chỗ này trước khi tải lên
+	importImagePlaceholder = "https://import.invalid/image"
+)
+
+// importColumns là các cột của file CSV, trùng với key của file JSON Lines. Cột image không thuộc dòng nào
+// (ảnh bìa database chọn qua API cover), chỉ được đọc để báo lỗi unknown_field cho từng dòng thay vì từ chối cả file.
+var importColumns = []string{"type", "id", "title", "description", "image", "database_id", "name", "image_urls"}
+
+// ImportRow là kết quả của một dòng dữ liệu
+type ImportRow struct {
+	Row     int                 `json:"row"` // số dòng trong file dữ liệu (CSV tính cả dòng header)
+	Type    string              `json:"type"`
+	ID      string              `json:"id,omitempty"`
+	Status  string              `json:"status"`
+	Errors  []apperr.FieldError `json:"errors,omitempty"`
+	Message string              `json:"message,omitempty"`
+}
+
+// ImportReport tổng kết một lần import
+type ImportReport struct {
+	DryRun           bool        `json:"dry_run"`
+	Total            int         `json:"total"`
+	Invalid          int         `json:"invalid"`
+	Failed           int         `json:"failed"`
+	DatabasesCreated int         `json:"databases_created"`
+	PiecesCreated    int         `json:"pieces_created"`
+	Rows             []ImportRow `json:"rows"`
+}
+
+// ImportSource là dữ liệu đã đọc từ file import, kèm ảnh nếu file là ZIP
+type ImportSource struct {
+	records []importRecord
+	images  map[string]*zip.File
+}
+
+// importRecord là một dòng dữ liệu, dùng chung cho database và piece
+type importRecord struct {
+	Type        string   `json:"type"`
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Image       string   `json:"image"`
+	DatabaseID  string   `json:"database_id"`
+	Name        string   `json:"name"`
+	ImageUrls   []string `json:"image_urls"`
+
+	line     int
+	parseErr string
+}
+
+// ParseImport đọc file import theo phần mở rộng: .csv, .jsonl/.ndjson hoặc .zip
+// (một file dữ liệu ở thư mục gốc, các ảnh được tham chiếu bằng đường dẫn tương đối trong ZIP)
+func ParseImport(filename string, r io.ReaderAt, size int64) (*ImportSource, error) {
+	var (
+		src *ImportSource
+		err error
+	)
+	switch ext := strings.ToLower(path.Ext(filename)); ext {
+	case ".zip":
+		src, err = parseImportZip(r, size)
+	case ".csv", ".jsonl", ".ndjson":
+		var records []importRecord
+		records, err = parseImportData(ext, io.NewSectionReader(r, 0, size))
+		src = &ImportSource{records: records}
+	default:
+		return nil, apperr.BadRequest("Unsupported import file type, expected .csv, .jsonl or .zip")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(src.records) == 0 {
+		return nil, apperr.BadRequest("Import file has no rows")
+	}
+	if len(src.records) > config.ImportMaxRows {
+		return nil, apperr.BadRequest(fmt.Sprintf("Import file has %d rows, the limit is %d", len(src.records), config.ImportMaxRows))
+	}
+	return src, nil
+}
+
+func parseImportZip(r io.ReaderAt, size int64) (*ImportSource, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, apperr.BadRequest("Invalid ZIP file: " + err.Error())
+	}
+
+	src := &ImportSource{images: make(map[string]*zip.File)}
+	var data *zip.File
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+		if f.UncompressedSize64 > uint64(config.ImportMaxBytes) {
+			return nil, apperr.BadRequest(fmt.Sprintf("ZIP entry %s is too large", name))
+		}
+
+		switch path.Ext(strings.ToLower(name)) {
+		case ".csv", ".jsonl", ".ndjson":
+			if !strings.Contains(name, "/") {
+				if data != nil {
+					return nil, apperr.BadRequest("ZIP must contain exactly one data file (.csv or .jsonl) at its root")
+				}
+				data = f
+				continue
+			}
+		}
+		src.images[name] = f
+	}
+	if data == nil {
+		return nil, apperr.BadRequest("ZIP must contain exactly one data file (.csv or .jsonl) at its root")
+	}
+
+	rc, err := data.Open()
+	if err != nil {
+		return nil, apperr.BadRequest("Invalid ZIP file: " + err.Error())
+	}
+	defer rc.Close()
+
+	src.records, err = parseImportData(strings.ToLower(path.Ext(data.Name)), rc)
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+func parseImportData(ext string, r io.Reader) ([]importRecord, error) {
+	if ext == ".csv" {
+		return parseImportCSV(r)
+	}
+	return parseImportJSONL(r)
+}
+
+// parseImportCSV đọc CSV có dòng header, image_urls gồm nhiều URL cách nhau bởi "|"
+func parseImportCSV(r io.Reader) ([]importRecord, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, apperr.BadRequest("CSV file is empty")
+	}
+	if err != nil {
+		return nil, apperr.BadRequest("Invalid CSV: " + err.Error())
+	}
+
+	columns := make([]string, len(header))
+	hasType := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(importColumns, name) {
+			return nil, apperr.BadRequest(fmt.Sprintf("Unknown CSV column %q, expected %s", name, strings.Join(importColumns, ", ")))
+		}
+		columns[i] = name
+		hasType = hasType || name == "type"
+	}
+	if !hasType {
+		return nil, apperr.BadRequest(`CSV must have a "type" column`)
+	}
+
+	var records []importRecord
+	for {
+		values, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, apperr.BadRequest("Invalid CSV: " + err.Error())
+		}
+		if strings.TrimSpace(strings.Join(values, "")) == "" {
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+		rec := importRecord{line: line}
+		for i, value := range values {
+			value = strings.TrimSpace(value)
+			switch columns[i] {
+			case "type":
+				rec.Type = value
+			case "id":
+				rec.ID = value
+			case "title":
+				rec.Title = value
+			case "description":
+				rec.Description = value
+			case "image":
+				rec.Image = value
+			case "database_id":
+				rec.DatabaseID = value
+			case "name":
+				rec.Name = value
+			case "image_urls":
+				for _, url := range strings.Split(value, "|") {
+					if url = strings.TrimSpace(url); url != "" {
+						rec.ImageUrls = append(rec.ImageUrls, url)
+					}
+				}
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// parseImportJSONL đọc mỗi dòng một object JSON, dòng sai cú pháp chỉ làm dòng đó không hợp lệ
+func parseImportJSONL(r io.Reader) ([]importRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var records []importRecord
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		rec := importRecord{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			rec = importRecord{parseErr: err.Error()}
+		}
+		rec.line = line
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, apperr.BadRequest("Invalid JSON Lines file: " + err.Error())
+	}
+	return records, nil
+}
+
+// importJob giữ dữ liệu của một dòng hợp lệ trong lúc ghi
+type importJob struct {
+	row      *ImportRow
+	db       *models.WoodDatabase
+	piece    *models.WoodPiece
+	uploaded []string // URL của ảnh tải lên từ ZIP cho dòng này
+}
+
+// Import kiểm tra toàn bộ các dòng rồi ghi vào Firestore. Nếu có dòng không hợp lệ thì không ghi gì
+// (report.Invalid > 0) để có thể sửa file và import lại mà không sinh piece trùng.
+// dryRun chỉ kiểm tra, không tải ảnh và không ghi.
+func Import(src *ImportSource, createdBy string, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{
+		DryRun: dryRun,
+		Total:  len(src.records),
+		Rows:   make([]ImportRow, len(src.records)),
+	}
+
+	v := &importValidator{src: src, declared: make(map[string]bool), active: make(map[string]bool), images: make(map[string]error)}
+	jobs := make([]*importJob, len(src.records))
+
+	// Database được kiểm tra trước để piece có thể tham chiếu database khai báo ở bất kỳ đâu trong file
+	for _, pass := range []string{importTypeDatabase, importTypePiece} {
+		for i, rec := range src.records {
+			isDatabase := rec.Type == importTypeDatabase
+			if (pass == importTypeDatabase) != isDatabase {
+				continue
+			}
+
+			row := &report.Rows[i]
+			row.Row, row.Type, row.ID = rec.line, rec.Type, rec.ID
+			job, details, err := v.check(rec)
+			if err != nil {
+				return nil, err
+			}
+			if len(details) > 0 {
+				row.Status, row.Errors = ImportInvalid, details
+				report.Invalid++
+				continue
+			}
+			row.Status = ImportValid
+			job.row = row
+			jobs[i] = job
+		}
+	}
+
+	if report.Invalid > 0 || dryRun {
+		return report, nil
+	}
+
+	writeImport(src, jobs, createdBy, report)
+	return report, nil
+}
+
+// importValidator kiểm tra từng dòng, ghi nhớ database khai báo trong file và database đã tra trên Firestore
+type importValidator struct {
+	src      *ImportSource
+	declared map[string]bool  // database hợp lệ khai báo trong file
+	active   map[string]bool  // kết quả tra database đang hoạt động trên Firestore
+	images   map[string]error // kết quả kiểm tra ảnh trong ZIP
+}
+
+func (v *importValidator) check(rec importRecord) (*importJob, []apperr.FieldError, error) {
+	if rec.parseErr != "" {
+		return nil, []apperr.FieldError{{Field: "row", Code: "invalid_json", Message: rec.parseErr}}, nil
+	}
+
+	switch rec.Type {
+	case importTypeDatabase:
+		return v.checkDatabase(rec)
+	case importTypePiece:
+		return v.checkPiece(rec)
+	}
+	return nil, []apperr.FieldError{{Field: "type", Code: "oneof", Message: "type must be one of: database piece"}}, nil
+}
+
+func (v *importValidator) checkDatabase(rec importRecord) (*importJob, []apperr.FieldError, error) {
+	details := unexpectedFields(rec, map[string]bool{"image": rec.Image != "", "database_id": rec.DatabaseID != "", "name": rec.Name != "", "image_urls": len(rec.ImageUrls) > 0})
+
+	db := &models.WoodDatabase{ID: rec.ID, Title: rec.Title, Description: rec.Description}
+	details = append(details, validationDetails(*db)...)
+
+	if db.ID != "" {
+		if v.declared[db.ID] {
+			details = append(details, apperr.FieldError{Field: "id", Code: "duplicate", Message: "id is declared more than once in this file"})
+		} else {
+			exists, err := firestore.DocumentExists("wood_database", db.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if exists {
+				details = append(details, apperr.FieldError{Field: "id", Code: "already_exists", Message: "a collection with this id already exists (possibly in trash)"})
+			}
+		}
+	}
+
+	if len(details) > 0 {
+		return nil, details, nil
+	}
+	v.declared[db.ID] = true
+	return &importJob{db: db}, nil, nil
+}
+
+func (v *importValidator) checkPiece(rec importRecord) (*importJob, []apperr.FieldError, error) {
+	details := unexpectedFields(rec, map[string]bool{"title": rec.Title != "", "image": rec.Image != ""})
+	if rec.ID != "" {
+		details = append(details, apperr.FieldError{Field: "id", Code: "read_only", Message: "id of a piece is assigned by the server"})
+	}
+
+	piece := &models.WoodPiece{DatabaseID: rec.DatabaseID, Name: rec.Name, Description: rec.Description, ImageUrls: rec.ImageUrls}
+	check := *piece
+	check.ImageUrls = append([]string(nil), piece.ImageUrls...)
+	names := make([]string, len(check.ImageUrls))
+	refs := make([]*string, len(check.ImageUrls))
+	for i := range check.ImageUrls {
+		names[i] = fmt.Sprintf("image_urls[%d]", i)
+		refs[i] = &check.ImageUrls[i]
+	}
+	details = append(details, v.checkImages(names, refs...)...)
+	fieldErrs := validationDetails(check)
+	details = append(details, fieldErrs...)
+
+	invalidDatabaseID := slices.ContainsFunc(fieldErrs, func(d apperr.FieldError) bool { return d.Field == "database_id" })
+	if piece.DatabaseID != "" && !invalidDatabaseID {
+		ok, err := v.databaseExists(piece.DatabaseID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			details = append(details, apperr.FieldError{
+				Field:   "database_id",
+				Code:    "not_found",
+				Message: "database_id does not refer to an existing collection or a valid database row in this file",
+			})
+		}
+	}
+
+	if len(details) > 0 {
+		return nil, details, nil
+	}
+	return &importJob{piece: piece}, nil, nil
+}
+
+// databaseExists kiểm tra database được khai báo hợp lệ trong file hoặc đang hoạt động trên Firestore
+func (v *importValidator) databaseExists(id string) (bool, error) {
+	if v.declared[id] {
+		return true, nil
+	}
+	if ok, checked := v.active[id]; checked {
+		return ok, nil
+	}
+
+	db, _, err := firestore.Get[models.WoodDatabase]("wood_database", id)
+	if errors.Is(err, firestore.ErrNotFound) {
+		v.active[id] = false
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	v.active[id] = db.DeletedAt == nil
+	return v.active[id], nil
+}
+
+// checkImages kiểm tra các ảnh tham chiếu tới file trong ZIP và thay bằng URL giữ chỗ
+// để validate các rule còn lại. refs trỏ vào field ảnh của bản sao dùng để validate.
+func (v *importValidator) checkImages(names []string, refs ...*string) []apperr.FieldError {
+	var details []apperr.FieldError
+	for i, ref := range refs {
+		if *ref == "" || isRemoteURL(*ref) {
+			continue
+		}
+		if v.src.images == nil {
+			details = append(details, apperr.FieldError{Field: names[i], Code: "http_url", Message: names[i] + " must be a valid http(s) URL (local files are only allowed in a ZIP import)"})
+			continue
+		}
+		name := path.Clean(*ref)
+		f, ok := v.src.images[name]
+		if !ok {
+			details = append(details, apperr.FieldError{Field: names[i], Code: "not_found", Message: names[i] + " refers to a file that is not in the ZIP"})
+			continue
+		}
+		if _, checked := v.images[name]; !checked {
+			v.images[name] = checkZipImage(f)
+		}
+		var appErr *apperr.Error
+		if errors.As(v.images[name], &appErr) {
+			details = append(details, apperr.FieldError{Field: names[i], Code: appErr.Code, Message: names[i] + ": " + appErr.Message})
+			continue
+		}
+		*ref = importImagePlaceholder
+	}
+	return details
+}
+
+// checkZipImage kiểm tra định dạng và kích thước của ảnh trong ZIP như khi upload
+func checkZipImage(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return apperr.BadRequest(err.Error())
+	}
+	defer rc.Close()
+	_, _, err = ReadImage(rc)
+	var appErr *apperr.Error
+	if err != nil && !errors.As(err, &appErr) {
+		return apperr.BadRequest("cannot read image: " + err.Error())
+	}
+	return err
+}
+
+// writeImport tải ảnh trong ZIP lên rồi ghi database trước, piece sau (theo từng database).
+// Dòng không ghi được được đánh dấu failed và ảnh đã tải lên cho nó bị xóa.
+func writeImport(src *ImportSource, jobs []*importJob, createdBy string, report *ImportReport) {
+	uploaded := uploadImportImages(src, jobs, createdBy)
+
+	var dbJobs []*importJob
+	pieceJobs := make(map[string][]*importJob)
+	var dbOrder []string
+	for _, job := range jobs {
+		if job == nil {
+			continue
+		}
+		if err := resolveImportImages(job, uploaded); err != nil {
+			failImportJob(job, err, report)
+			continue
+		}
+		if job.db != nil {
+			dbJobs = append(dbJobs, job)
+			continue
+		}
+		id := job.piece.DatabaseID
+		if _, ok := pieceJobs[id]; !ok {
+			dbOrder = append(dbOrder, id)
+		}
+		pieceJobs[id] = append(pieceJobs[id], job)
+	}
+
+	dbs := make([]*models.WoodDatabase, len(dbJobs))
+	for i, job := range dbJobs {
+		dbs[i] = job.db
+	}
+	for i, err := range firestore.CreateWoodDatabases(dbs, createdBy) {
+		if err != nil {
+			failImportJob(dbJobs[i], err, report)
+			continue
+		}
+		dbJobs[i].row.Status = ImportCreated
+		report.DatabasesCreated++
+		PublishChange(ChangeCreated, "wood_database", dbs[i].ID, dbs[i].ID, dbs[i])
+	}
+
+	for _, databaseID := range dbOrder {
+		group := pieceJobs[databaseID]
+		pieces := make([]*models.WoodPiece, len(group))
+		for i, job := range group {
+			pieces[i] = job.piece
+		}
+
+		created, err := firestore.CreateWoodPieces(databaseID, pieces, createdBy)
+		for i, job := range group {
+			if i >= created {
+				failImportJob(job, err, report)
+				continue
+			}
+			job.row.ID, job.row.Status = job.piece.ID, ImportCreated
+			report.PiecesCreated++
+			PublishChange(ChangeCreated, "wood_piece", job.piece.ID, databaseID, job.piece)
+		}
+	}
+
+	releaseUnusedUploads(jobs)
+}
+
+// releaseUnusedUploads để lại cho GC các ảnh đã tải lên từ ZIP mà không dòng nào ghi thành công dùng tới.
+// Ảnh mới tải lên chưa có owner và đã có unreferenced_since (xem UploadImage) nên không cần xóa ngay:
+// GC tính lại owner từ dữ liệu trước khi xóa, ảnh được document khác dùng tới trong thời gian grace vẫn được giữ.
+func releaseUnusedUploads(jobs []*importJob) {
+	used := make(map[string]bool)
+	var all []string
+	for _, job := range jobs {
+		if job == nil {
+			continue
+		}
+		for _, url := range job.uploaded {
+			if !used[url] && job.row.Status == ImportCreated {
+				used[url] = true
+			}
+			all = append(all, url)
+		}
+	}
+
+	var unused []string
+	for _, url := range all {
+		if !used[url] && !slices.Contains(unused, url) {
+			unused = append(unused, url)
+		}
+	}
+	if len(unused) > 0 {
+		log.Printf("import: %d uploaded images of failed rows left for GC", releaseImages("", unused))
+	}
+}
+
+// uploadImportImages tải các ảnh trong ZIP được các dòng hợp lệ tham chiếu, mỗi file một lần.
+// Trả về map đường dẫn trong ZIP -> URL, hoặc lỗi nếu file đó tải lên thất bại.
+func uploadImportImages(src *ImportSource, jobs []*importJob, createdBy string) map[string]importUpload {
+	var names []string
+	seen := make(map[string]bool)
+	for _, job := range jobs {
+		if job == nil {
+			continue
+		}
+		for _, ref := range importImageRefs(job) {
+			if name := path.Clean(*ref); !isRemoteURL(*ref) && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	uploaded := make(map[string]importUpload, len(names))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, importUploadWorkers)
+	for _, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			url, err := uploadZipImage(src.images[name], createdBy)
+			mu.Lock()
+			uploaded[name] = importUpload{url: url, err: err}
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return uploaded
+}
+
+type importUpload struct {
+	url string
+	err error
+}
+
+func uploadZipImage(f *zip.File, createdBy string) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	asset, err := UploadImage(context.Background(), rc, UploadOptions{CreatedBy: createdBy})
+	if err != nil {
+		return "", err
+	}
+	return asset.URL, nil
+}
+
+// resolveImportImages thay đường dẫn trong ZIP bằng URL đã tải lên
+func resolveImportImages(job *importJob, uploaded map[string]importUpload) error {
+	for _, ref := range importImageRefs(job) {
+		if *ref == "" || isRemoteURL(*ref) {
+			continue
+		}
+		up := uploaded[path.Clean(*ref)]
+		if up.err != nil {
+			return fmt.Errorf("upload %s: %w", *ref, up.err)
+		}
+		*ref = up.url
+		job.uploaded = append(job.uploaded, up.url)
+	}
+	return nil
+}
+
+func importImageRefs(job *importJob) []*string {
+	if job.db != nil {
+		return nil
+	}
+	refs := make([]*string, len(job.piece.ImageUrls))
+	for i := range job.piece.ImageUrls {
+		refs[i] = &job.piece.ImageUrls[i]
+	}
+	return refs
+}
+
+// failImportJob đánh dấu dòng ghi lỗi. Ảnh đã tải lên cho dòng đó không bị xóa ở đây mà được
+// releaseUnusedUploads để lại cho GC khi không còn dòng nào ghi thành công dùng tới.
+func failImportJob(job *importJob, err error, report *ImportReport) {
+	job.row.Status = ImportFailed
+	job.row.Message = err.Error()
+	report.Failed++
+}
+
+// unexpectedFields báo lỗi các field không thuộc loại dòng đang xét nhưng lại có giá trị
+func unexpectedFields(rec importRecord, present map[string]bool) []apperr.FieldError {
+	var details []apperr.FieldError
+	for _, name := range importColumns {
+		if present[name] {
+			details = append(details, apperr.FieldError{Field: name, Code: "unknown_field", Message: name + " is not a field of " + rec.Type + " rows"})
+		}
+	}
+	return details
+}
+
+func validationDetails(obj interface{}) []apperr.FieldError {
+	err := validation.Struct(obj)
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return appErr.Details
+	}
+	if err != nil {
+		return []apperr.FieldError{{Field: "row", Code: "invalid", Message: err.Error()}}
+	}
+	return nil
+}
+
+func isRemoteURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
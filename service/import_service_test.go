@@ -0,0 +1,28 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+// Ảnh bìa database chỉ chọn qua API cover, cột image trên dòng database bị báo lỗi thay vì được ghi
+func TestImportDatabaseRejectsImage(t *testing.T) {
+	src, err := parseImportCSV(strings.NewReader("type,title,image\ndatabase,Oak,https://a/1.jpg\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &importValidator{declared: make(map[string]bool), active: make(map[string]bool), images: make(map[string]error)}
+	job, details, err := v.checkDatabase(src[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job != nil {
+		t.Fatalf("database row with image accepted: %+v", job.db)
+	}
+	for _, d := range details {
+		if d.Field == "image" && d.Code == "unknown_field" {
+			return
+		}
+	}
+	t.Errorf("details = %+v, want unknown_field for image", details)
+}
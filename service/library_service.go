@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+)
+
+// PurgeReport tổng kết một lần dọn thùng rác
+type PurgeReport struct {
+	Databases int `json:"databases"`
+	Pieces    int `json:"pieces"`
+	// Số ảnh không còn owner sau khi purge, được GC ảnh xóa sau IMAGE_GC_GRACE
+	Images     int `json:"images"`
+	Tombstones int `json:"tombstones"`
+}
+
+// PurgeTrash xóa vĩnh viễn các database và piece đã nằm trong thùng rác lâu hơn retention.
+// Ảnh không bị xóa ngay mà chỉ bỏ owner (xem releaseImages), GC ảnh xóa khi không còn ai dùng.
+func PurgeTrash(retention time.Duration) (*PurgeReport, error) {
+	cutoff := time.Now().Add(-retention)
+	report := &PurgeReport{}
+
+	dbs, err := firestore.ListDeleted[models.WoodDatabase]("wood_database", cutoff)
+	if err != nil {
+		return report, err
+	}
+	for _, db := range dbs {
+		pieces, err := firestore.PurgeWoodDatabase(db.ID)
+		if isGone(err) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Databases++
+		report.Pieces += len(pieces)
+		report.Images += releaseImages("wood_database/"+db.ID, []string{db.Image})
+		for _, p := range pieces {
+			report.Images += releaseImages("wood_piece/"+p.ID, p.ImageUrls)
+		}
+	}
+
+	pieces, err := firestore.ListDeleted[models.WoodPiece]("wood_piece", cutoff)
+	if err != nil {
+		return report, err
+	}
+	for _, p := range pieces {
+		purged, err := firestore.PurgeWoodPiece(p.ID)
+		if isGone(err) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Pieces++
+		report.Images += releaseImages("wood_piece/"+p.ID, purged.ImageUrls)
+	}
+
+	report.Tombstones, err = firestore.PurgeTombstones(time.Now().Add(-config.TombstoneRetention))
+	return report, err
+}
+
+// isGone cho biết item đã bị xóa hoặc được khôi phục bởi request khác trong lúc purge
+func isGone(err error) bool {
+	return errors.Is(err, firestore.ErrNotInTrash) ||
+		errors.Is(err, firestore.ErrDatabaseNotFound) ||
+		errors.Is(err, firestore.ErrPieceNotFound)
+}
+
+// StartTrashPurger chạy PurgeTrash định kỳ trong background
+func StartTrashPurger(retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			report, err := PurgeTrash(retention)
+			if err != nil {
+				log.Println("Trash purge failed:", err)
+				continue
+			}
+			if report.Databases > 0 || report.Pieces > 0 || report.Tombstones > 0 {
+				log.Printf("Trash purged: %d databases, %d pieces, %d images left for GC, %d expired tombstones",
+					report.Databases, report.Pieces, report.Images, report.Tombstones)
+			}
+		}
+	}()
+}
+
+// SizeFix ghi lại một database có size bị lệch so với số piece thực tế
+type SizeFix struct {
+	DatabaseID string `json:"database_id"`
+	OldSize    int    `json:"old_size"`
+	NewSize    int    `json:"new_size"`
+}
+
+// RecomputeDatabaseSizes đếm lại size của mọi database, trả về các database đã được sửa
+func RecomputeDatabaseSizes() ([]SizeFix, error) {
+	ids, err := firestore.ListDocumentIDs("wood_database")
+	if err != nil {
+		return nil, err
+	}
+
+	var fixes []SizeFix
+	for _, id := range ids {
+		oldSize, newSize, err := firestore.RecomputeWoodDatabaseSize(id)
+		if errors.Is(err, firestore.ErrDatabaseNotFound) {
+			continue
+		}
+		if err != nil {
+			return fixes, err
+		}
+		if oldSize != newSize {
+			fixes = append(fixes, SizeFix{DatabaseID: id, OldSize: oldSize, NewSize: newSize})
+		}
+	}
+	return fixes, nil
+}
+
+// BackfillUpdatedAt điền updated_at cho database và piece cũ chưa có, trả về số document đã cập nhật
+func BackfillUpdatedAt() (int, error) {
+	total := 0
+	for _, collection := range []string{"wood_database", "wood_piece"} {
+		n, err := firestore.BackfillUpdatedAt(collection)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// FindOrphanPieces trả về các piece có database_id không trỏ tới database nào
+func FindOrphanPieces() ([]models.WoodPiece, error) {
+	dbIDs, err := firestore.ListDocumentIDs("wood_database")
+	if err != nil {
+		return nil, err
+	}
+	databases := make(map[string]bool, len(dbIDs))
+	for _, id := range dbIDs {
+		databases[id] = true
+	}
+
+	pieces, err := firestore.List[models.WoodPiece]("wood_piece")
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []models.WoodPiece
+	for _, p := range pieces {
+		if !databases[p.DatabaseID] {
+			orphans = append(orphans, p)
+		}
+	}
+	return orphans, nil
+}
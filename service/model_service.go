@@ -1,18 +1,19 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
 
 	"backend/config"
-
-	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"backend/storage"
 )
 
 type ModelMetadata struct {
@@ -21,14 +22,17 @@ type ModelMetadata struct {
 	Size        int64  `json:"size"`
 	Checksum    string `json:"checksum"`
 	DownloadURL string `json:"download_url"`
+	// Labels là label map của model: class index -> nhãn (ID của wood database)
+	Labels []string `json:"labels,omitempty"`
 }
 
 type VersionEntry struct {
-	Version  int    `json:"version"`
-	File     string `json:"file"`
-	Name     string `json:"name"`
-	Checksum string `json:"checksum"`
-	Size     int64  `json:"size"`
+	Version  int      `json:"version"`
+	File     string   `json:"file"`
+	Name     string   `json:"name"`
+	Checksum string   `json:"checksum"`
+	Size     int64    `json:"size"`
+	Labels   []string `json:"labels,omitempty"`
 }
 
 type VersionInfo struct {
@@ -79,7 +83,7 @@ func WriteVersion(v *VersionInfo) error {
 	return os.WriteFile(VersionFilePath(), data, 0644)
 }
 
-func UploadNewModel(filePath string, version int, name string) (*VersionEntry, error) {
+func UploadNewModel(filePath string, version int, name string, labels []string) (*VersionEntry, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
@@ -87,14 +91,10 @@ func UploadNewModel(filePath string, version int, name string) (*VersionEntry, e
 	sum := sha256.Sum256(data)
 	checksum := hex.EncodeToString(sum[:])
 
-	ctx := context.Background()
-	publicID := fmt.Sprintf("models/%s", filepath.Base(filePath))
-	uploadResp, err := config.CLD.Upload.Upload(ctx, filePath, uploader.UploadParams{
-		PublicID:     publicID,
-		ResourceType: "raw",
-	})
+	key := "models/" + filepath.Base(filePath)
+	obj, err := storage.Default.Put(context.Background(), key, bytes.NewReader(data), storage.PutOptions{ContentType: "application/octet-stream"})
 	if err != nil {
-		return nil, fmt.Errorf("cloudinary upload failed: %v", err)
+		return nil, fmt.Errorf("model upload failed: %v", err)
 	}
 
 	entry := VersionEntry{
@@ -103,11 +103,12 @@ func UploadNewModel(filePath string, version int, name string) (*VersionEntry, e
 		Name:     name,
 		Checksum: checksum,
 		Size:     int64(len(data)),
+		Labels:   labels,
 	}
 
 	// Lưu URL download
-	entryDownloadURL := uploadResp.SecureURL
-	fmt.Println("Model uploaded to Cloudinary:", entryDownloadURL)
+	entryDownloadURL := obj.URL
+	log.Printf("model %d uploaded to blob store: %s", version, entryDownloadURL)
 
 	// Ghi metadata
 	vInfo, err := ReadVersion()
@@ -171,10 +172,27 @@ func GetCurrentModelMetadata() (*ModelMetadata, error) {
 		Size:        current.Size,
 		Checksum:    current.Checksum,
 		DownloadURL: current.File,
+		Labels:      current.Labels,
 	}
 	return meta, nil
 }
 
+// SetVersionLabels cập nhật label map của một version đã upload
+func SetVersionLabels(version int, labels []string) error {
+	vInfo, err := ReadVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := range vInfo.Versions {
+		if vInfo.Versions[i].Version == version {
+			vInfo.Versions[i].Labels = labels
+			return WriteVersion(vInfo)
+		}
+	}
+	return fmt.Errorf("version %d not found", version)
+}
+
 func ListVersions() ([]VersionEntry, error) {
 	vInfo, err := ReadVersion()
 	if err != nil {
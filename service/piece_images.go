@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+)
+
+// Trạng thái của từng file khi upload nhiều ảnh vào piece
+const (
+	PieceImageUploaded = "uploaded" // đã lưu và gắn vào piece
+	PieceImageFailed   = "failed"   // bị từ chối hoặc lỗi, xem code/message
+)
+
+// PieceImageFile là một file ảnh trong request upload nhiều ảnh
+type PieceImageFile struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// PieceImageResult là kết quả của từng file, theo đúng thứ tự file trong request
+type PieceImageResult struct {
+	Index    int                `json:"index"`
+	Filename string             `json:"filename"`
+	Status   string             `json:"status"`
+	Code     string             `json:"code,omitempty"`
+	Message  string             `json:"message,omitempty"`
+	Image    *models.ImageAsset `json:"image,omitempty"`
+}
+
+// PieceImagesReport tổng kết một lần upload nhiều ảnh vào piece
+type PieceImagesReport struct {
+	Uploaded int                `json:"uploaded"`
+	Failed   int                `json:"failed"`
+	Files    []PieceImageResult `json:"files"`
+	Piece    *models.WoodPiece  `json:"piece,omitempty"`
+}
+
+var ErrTooManyFiles = apperr.BadRequest("Too many files in one request")
+
+// UploadPieceImages tải các file lên song song (tối đa IMAGE_UPLOAD_CONCURRENCY file cùng lúc) rồi thêm URL của
+// các file thành công vào image_urls của piece trong một lần ghi, theo thứ tự file trong request.
+// File lỗi chỉ được ghi vào báo cáo; lỗi trả về chỉ khi không gắn được ảnh vào piece (khi đó các ảnh vừa lưu bị xóa).
+func UploadPieceImages(ctx context.Context, pieceID string, files []PieceImageFile, opts UploadOptions) (*PieceImagesReport, error) {
+	if len(files) > config.ImageUploadMaxFiles {
+		return nil, ErrTooManyFiles
+	}
+	piece, _, err := firestore.Get[models.WoodPiece]("wood_piece", pieceID)
+	if errors.Is(err, firestore.ErrNotFound) || (err == nil && piece.DeletedAt != nil) {
+		return nil, firestore.ErrPieceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(piece.ImageUrls)+len(files) > models.MaxPieceImages {
+		return nil, firestore.ErrTooManyImages
+	}
+
+	report := &PieceImagesReport{Files: make([]PieceImageResult, len(files))}
+	sem := make(chan struct{}, max(config.ImageUploadConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, f := range files {
+		report.Files[i] = PieceImageResult{Index: i, Filename: f.Name}
+		wg.Add(1)
+		go func(res *PieceImageResult, f PieceImageFile) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			asset, err := uploadPieceImage(ctx, f, opts)
+			if err != nil {
+				res.Code, res.Message = uploadErrorInfo(f.Name, err)
+				res.Status = PieceImageFailed
+				return
+			}
+			res.Status, res.Image = PieceImageUploaded, asset
+		}(&report.Files[i], f)
+	}
+	wg.Wait()
+
+	var urls []string
+	for _, res := range report.Files {
+		if res.Status == PieceImageUploaded {
+			urls = append(urls, res.Image.URL)
+			report.Uploaded++
+		} else {
+			report.Failed++
+		}
+	}
+	if len(urls) == 0 {
+		return report, nil
+	}
+
+	report.Piece, err = firestore.AddWoodPieceImages(pieceID, urls)
+	if err != nil {
+		DeleteImagesByURL(urls)
+		return nil, err
+	}
+	for _, res := range report.Files {
+		if res.Image == nil {
+			continue
+		}
+		if err := setImageOwners(res.Image, "wood_piece/"+pieceID); err != nil {
+			// Ảnh đã gắn vào piece, GC sẽ cập nhật owner ở lần chạy sau
+			log.Printf("cannot set owner of image %s: %v", res.Image.ID, err)
+		}
+	}
+	return report, nil
+}
+
+func uploadPieceImage(ctx context.Context, f PieceImageFile, opts UploadOptions) (*models.ImageAsset, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return UploadImage(ctx, rc, opts)
+}
+
+// uploadErrorInfo chuyển lỗi của một file thành code/message trả cho client; lỗi nội bộ chỉ ghi log
+func uploadErrorInfo(name string, err error) (code, message string) {
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return appErr.Code, appErr.Message
+	}
+	log.Printf("upload %q failed: %v", name, err)
+	return "internal", "Upload failed"
+}
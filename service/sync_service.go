@@ -0,0 +1,173 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"backend/apperr"
+	"backend/config"
+	"backend/firestore"
+	"backend/models"
+)
+
+const syncTokenVersion = 1
+
+// ErrSyncTokenExpired trả về khi token cũ hơn thời gian giữ tombstone, client phải đồng bộ lại từ đầu
+var ErrSyncTokenExpired = apperr.New(http.StatusGone, "sync_token_expired", "Sync token is too old, start a full sync without since")
+
+// SyncToken là vị trí đã đồng bộ tới của từng luồng thay đổi, được mã hóa base64 trả cho client
+type SyncToken struct {
+	Version int `json:"v"`
+	// IssuedAt là thời điểm cấp token, token cũ hơn thời gian giữ tombstone có thể đã bỏ lỡ thao tác xóa
+	IssuedAt   time.Time            `json:"at"`
+	Databases  firestore.SyncCursor `json:"d"`
+	Pieces     firestore.SyncCursor `json:"p"`
+	Tombstones firestore.SyncCursor `json:"x"`
+}
+
+// SyncChanges là các thay đổi của một loại tài nguyên. Item bị xóa mềm hoặc xóa vĩnh viễn nằm trong Deleted.
+type SyncChanges[T any] struct {
+	Upserted []T      `json:"upserted"`
+	Deleted  []string `json:"deleted"`
+}
+
+// SyncResponse là một trang kết quả delta sync
+type SyncResponse struct {
+	Databases SyncChanges[models.WoodDatabase] `json:"databases"`
+	Pieces    SyncChanges[models.WoodPiece]    `json:"pieces"`
+	NextToken string                           `json:"next_token"`
+	HasMore   bool                             `json:"has_more"`
+}
+
+// ParseSyncSince đọc tham số since: rỗng (đồng bộ toàn bộ), thời điểm RFC 3339 hoặc token từ lần sync trước
+func ParseSyncSince(since string) (*SyncToken, error) {
+	token := &SyncToken{Version: syncTokenVersion}
+	if since == "" {
+		return token, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		cursor := firestore.SyncCursor{Time: t}
+		token.Databases, token.Pieces, token.Tombstones = cursor, cursor, cursor
+		token.IssuedAt = t
+		return token, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(since)
+	if err != nil || json.Unmarshal(data, token) != nil || token.Version != syncTokenVersion {
+		return nil, apperr.BadRequest("since must be a sync token or an RFC 3339 timestamp")
+	}
+	return token, nil
+}
+
+func (t *SyncToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// syncEvent là một thay đổi trong luồng gộp của ba nguồn: database, piece và tombstone
+type syncEvent struct {
+	stream int // nguồn của thay đổi, dùng làm thứ tự phụ cho các thay đổi cùng thời điểm
+	id     string
+	time   time.Time
+
+	collection string
+	docID      string
+	deleted    bool
+	db         models.WoodDatabase
+	piece      models.WoodPiece
+}
+
+const (
+	streamDatabases = iota
+	streamPieces
+	streamTombstones
+)
+
+// Sync trả về tối đa limit thay đổi kể từ token, theo thứ tự thời gian, kèm token cho lần gọi tiếp theo.
+// Mỗi nguồn được đọc tối đa limit document rồi gộp lại, chỉ limit thay đổi sớm nhất được trả về
+// và cursor của từng nguồn chỉ tiến tới thay đổi cuối cùng đã trả.
+func Sync(token *SyncToken, limit int) (*SyncResponse, error) {
+	if !token.IssuedAt.IsZero() && time.Since(token.IssuedAt) > config.TombstoneRetention {
+		return nil, ErrSyncTokenExpired
+	}
+
+	dbs, err := firestore.ChangesSince[models.WoodDatabase]("wood_database", "updated_at", token.Databases, limit)
+	if err != nil {
+		return nil, err
+	}
+	pieces, err := firestore.ChangesSince[models.WoodPiece]("wood_piece", "updated_at", token.Pieces, limit)
+	if err != nil {
+		return nil, err
+	}
+	tombstones, err := firestore.ListTombstonesSince(token.Tombstones, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []syncEvent
+	for _, c := range dbs {
+		events = append(events, syncEvent{stream: streamDatabases, id: c.ID, time: c.Time,
+			collection: "wood_database", docID: c.ID, deleted: c.Doc.DeletedAt != nil, db: c.Doc})
+	}
+	for _, c := range pieces {
+		events = append(events, syncEvent{stream: streamPieces, id: c.ID, time: c.Time,
+			collection: "wood_piece", docID: c.ID, deleted: c.Doc.DeletedAt != nil, piece: c.Doc})
+	}
+	for _, c := range tombstones {
+		events = append(events, syncEvent{stream: streamTombstones, id: c.ID, time: c.Time,
+			collection: c.Doc.Collection, docID: c.Doc.DocID, deleted: true})
+	}
+
+	slices.SortFunc(events, func(a, b syncEvent) int {
+		if c := a.time.Compare(b.time); c != 0 {
+			return c
+		}
+		if a.stream != b.stream {
+			return a.stream - b.stream
+		}
+		return strings.Compare(a.id, b.id)
+	})
+
+	// Nguồn nào đọc đủ limit document thì có thể còn thay đổi phía sau
+	hasMore := len(events) > limit || len(dbs) == limit || len(pieces) == limit || len(tombstones) == limit
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	next := *token
+	next.IssuedAt = time.Now().UTC()
+	cursors := []*firestore.SyncCursor{&next.Databases, &next.Pieces, &next.Tombstones}
+	latest := make(map[string]int, len(events))
+	for i, e := range events {
+		*cursors[e.stream] = firestore.SyncCursor{Time: e.time, ID: e.id}
+		latest[e.collection+"/"+e.docID] = i
+	}
+
+	resp := &SyncResponse{
+		Databases: SyncChanges[models.WoodDatabase]{Upserted: []models.WoodDatabase{}, Deleted: []string{}},
+		Pieces:    SyncChanges[models.WoodPiece]{Upserted: []models.WoodPiece{}, Deleted: []string{}},
+		NextToken: next.encode(),
+		HasMore:   hasMore,
+	}
+	// Một item thay đổi nhiều lần trong trang chỉ trả trạng thái cuối cùng
+	for i, e := range events {
+		if latest[e.collection+"/"+e.docID] != i {
+			continue
+		}
+		switch {
+		case e.collection == "wood_database" && e.deleted:
+			resp.Databases.Deleted = append(resp.Databases.Deleted, e.docID)
+		case e.collection == "wood_database":
+			resp.Databases.Upserted = append(resp.Databases.Upserted, e.db)
+		case e.collection == "wood_piece" && e.deleted:
+			resp.Pieces.Deleted = append(resp.Pieces.Deleted, e.docID)
+		case e.collection == "wood_piece":
+			resp.Pieces.Upserted = append(resp.Pieces.Upserted, e.piece)
+		}
+	}
+	return resp, nil
+}
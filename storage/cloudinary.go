@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// CloudinaryStore lưu ảnh dưới dạng image (public ID bỏ phần mở rộng) và file khác dưới dạng raw.
+// URL của Cloudinary là công khai nên SignedURL trả về URL thường, trừ file dưới StagingPrefix được lưu với
+// delivery type authenticated và chỉ đọc được qua URL tải xuống có chữ ký.
+type CloudinaryStore struct {
+	cld *cloudinary.Cloudinary
+}
+
+func NewCloudinaryStore(cld *cloudinary.Cloudinary) *CloudinaryStore {
+	return &CloudinaryStore{cld: cld}
+}
+
+// asset trả về resource type và public ID của key: ảnh bỏ phần mở rộng, raw giữ nguyên
+func (s *CloudinaryStore) asset(key string) (resourceType, publicID string, err error) {
+	key, err = cleanKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.HasPrefix(contentTypeFor(key), "image/") {
+		return string(api.Image), strings.TrimSuffix(key, path.Ext(key)), nil
+	}
+	return api.File, key, nil
+}
+
+// deliveryType của key: file tạm dưới StagingPrefix không có URL công khai
+func (s *CloudinaryStore) deliveryType(key string) api.DeliveryType {
+	if strings.HasPrefix(strings.TrimPrefix(key, "/"), StagingPrefix) {
+		return api.Authenticated
+	}
+	return api.Upload
+}
+
+// Thời hạn URL tải xuống có chữ ký của file tạm
+const cloudinaryPrivateURLTTL = 10 * time.Minute
+
+// privateURL trả về URL tải xuống có chữ ký (qua API của Cloudinary) cho asset không công khai
+func (s *CloudinaryStore) privateURL(key, format string) (string, error) {
+	resourceType, publicID, err := s.asset(key)
+	if err != nil {
+		return "", err
+	}
+	expires := time.Now().Add(cloudinaryPrivateURLTTL)
+	return s.cld.Upload.PrivateDownloadURL(uploader.PrivateDownloadURLParams{
+		PublicID:     publicID,
+		Format:       format,
+		DeliveryType: string(s.deliveryType(key)),
+		ExpiresAt:    &expires,
+		ResourceType: api.AssetType(resourceType),
+	})
+}
+
+func (s *CloudinaryStore) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*Object, error) {
+	resourceType, publicID, err := s.asset(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.cld.Upload.Upload(ctx, r, uploader.UploadParams{
+		PublicID:     publicID,
+		ResourceType: resourceType,
+		Type:         s.deliveryType(key),
+		Overwrite:    api.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error.Message != "" {
+		return nil, fmt.Errorf("cloudinary upload failed: %s", resp.Error.Message)
+	}
+	return &Object{
+		Key:         key,
+		URL:         resp.SecureURL,
+		ContentType: contentTypeFor(key),
+		Size:        int64(resp.Bytes),
+		ModTime:     resp.CreatedAt.UTC(),
+	}, nil
+}
+
+func (s *CloudinaryStore) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	obj, format, err := s.stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := obj.URL
+	if s.deliveryType(key) != api.Upload {
+		if u, err = s.privateURL(key, format); err != nil {
+			return nil, nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("cloudinary download %s: %s", key, resp.Status)
+	}
+	return resp.Body, obj, nil
+}
+
+func (s *CloudinaryStore) Delete(ctx context.Context, key string) error {
+	resourceType, publicID, err := s.asset(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.cld.Upload.Destroy(ctx, uploader.DestroyParams{
+		PublicID:     publicID,
+		Type:         string(s.deliveryType(key)),
+		ResourceType: resourceType,
+	})
+	if err != nil {
+		return err
+	}
+	// Destroy trả "not found" trong Result khi asset không tồn tại, coi như đã xóa
+	if resp.Error.Message != "" {
+		return fmt.Errorf("cloudinary destroy failed: %s", resp.Error.Message)
+	}
+	return nil
+}
+
+func (s *CloudinaryStore) Stat(ctx context.Context, key string) (*Object, error) {
+	obj, _, err := s.stat(ctx, key)
+	return obj, err
+}
+
+// stat đọc thông tin asset kèm định dạng Cloudinary nhận dạng từ nội dung file. Content-Type của ảnh lấy theo
+// định dạng đó thay vì phần mở rộng của key để biết file thật sự được upload là gì.
+func (s *CloudinaryStore) stat(ctx context.Context, key string) (*Object, string, error) {
+	resourceType, publicID, err := s.asset(key)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := s.cld.Admin.Asset(ctx, admin.AssetParams{
+		AssetType:    api.AssetType(resourceType),
+		DeliveryType: s.deliveryType(key),
+		PublicID:     publicID,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if msg := resp.Error.Message; msg != "" {
+		if strings.Contains(strings.ToLower(msg), "not found") {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", fmt.Errorf("cloudinary asset failed: %s", msg)
+	}
+	contentType := contentTypeFor(key)
+	if resourceType == string(api.Image) && resp.Format != "" {
+		contentType = contentTypeFor("." + resp.Format)
+	}
+	return &Object{
+		Key:         key,
+		URL:         resp.SecureURL,
+		ContentType: contentType,
+		Size:        int64(resp.Bytes),
+		ModTime:     resp.CreatedAt.UTC(),
+	}, resp.Format, nil
+}
+
+func (s *CloudinaryStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	obj, format, err := s.stat(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if s.deliveryType(key) != api.Upload {
+		return s.privateURL(key, format)
+	}
+	return obj.URL, nil
+}
+
+// Cloudinary chỉ chấp nhận chữ ký upload trong vòng 1 giờ kể từ timestamp
+const cloudinaryMaxUploadTTL = time.Hour
+
+// PresignUpload trả về tham số signed upload (POST multipart tới Upload API). Chữ ký ràng buộc public ID,
+// resource type, delivery type (file tạm là authenticated, không có URL công khai), định dạng ảnh theo phần
+// mở rộng của key (allowed_formats) và thời điểm ký. Cloudinary không ràng buộc dung lượng trong chữ ký nên
+// dung lượng được kiểm tra lại bằng Stat trước khi tải file về.
+func (s *CloudinaryStore) PresignUpload(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (*UploadTicket, error) {
+	resourceType, publicID, err := s.asset(key)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	params := url.Values{
+		"public_id": {publicID},
+		"timestamp": {strconv.FormatInt(now.Unix(), 10)},
+		"type":      {string(s.deliveryType(key))},
+	}
+	if resourceType == string(api.Image) {
+		params.Set("allowed_formats", strings.TrimPrefix(path.Ext(key), "."))
+	}
+	signature, err := api.SignParameters(params, s.cld.Config.Cloud.APISecret)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{
+		"api_key":   s.cld.Config.Cloud.APIKey,
+		"signature": signature,
+	}
+	for name := range params {
+		fields[name] = params.Get(name)
+	}
+	return &UploadTicket{
+		Method: http.MethodPost,
+		URL: fmt.Sprintf("%s/v1_1/%s/%s/upload", strings.TrimRight(s.cld.Config.API.UploadPrefix, "/"),
+			s.cld.Config.Cloud.CloudName, resourceType),
+		Fields:    fields,
+		ExpiresAt: now.Add(min(ttl, cloudinaryMaxUploadTTL)),
+	}, nil
+}
+
+var cloudinaryVersionSegment = regexp.MustCompile(`^v\d+$`)
+
+// KeyFromURL lấy key từ secure URL của Cloudinary, ví dụ
+// https://res.cloudinary.com/<cloud>/image/upload/v123/images/abc.jpg -> images/abc.jpg
+func (s *CloudinaryStore) KeyFromURL(url string) (string, bool) {
+	base, rest, ok := strings.Cut(url, "/upload/")
+	if !ok || rest == "" || !strings.Contains(base, "res.cloudinary.com") {
+		return "", false
+	}
+
+	segments := strings.Split(rest, "/")
+	for i, seg := range segments {
+		if cloudinaryVersionSegment.MatchString(seg) {
+			segments = segments[i+1:]
+			break
+		}
+	}
+
+	key := strings.Join(segments, "/")
+	if key == "" {
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+)
+
+func TestCloudinaryPresignUploadStagingIsAuthenticated(t *testing.T) {
+	cld, err := cloudinary.NewFromParams("demo", "key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewCloudinaryStore(cld)
+
+	cases := []struct {
+		key      string
+		typ      string
+		publicID string
+		format   string
+	}{
+		{key: StagingPrefix + "abc.jpg", typ: api.Authenticated, publicID: "uploads/abc", format: "jpg"},
+		{key: "images/abc.webp", typ: string(api.Upload), publicID: "images/abc", format: "webp"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.key, func(t *testing.T) {
+			ticket, err := s.PresignUpload(t.Context(), tc.key, "image/jpeg", 100, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := ticket.Fields
+			if f["type"] != tc.typ || f["public_id"] != tc.publicID {
+				t.Errorf("type = %q, public_id = %q", f["type"], f["public_id"])
+			}
+			if f["allowed_formats"] != tc.format {
+				t.Errorf("allowed_formats = %q, want %q", f["allowed_formats"], tc.format)
+			}
+
+			// Mọi field trừ api_key và signature phải nằm trong chữ ký
+			params := url.Values{}
+			for name, v := range f {
+				if name != "api_key" && name != "signature" {
+					params.Set(name, v)
+				}
+			}
+			want, err := api.SignParameters(params, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f["signature"] != want {
+				t.Errorf("signature does not cover the returned fields %v", f)
+			}
+		})
+	}
+}
+
+func TestCloudinaryKeyDeliveryType(t *testing.T) {
+	s := &CloudinaryStore{}
+	for key, want := range map[string]api.DeliveryType{
+		StagingPrefix + "a.jpg": api.Authenticated,
+		"/uploads/a.jpg":        api.Authenticated,
+		"images/a.jpg":          api.Upload,
+		"models/uploads.tflite": api.Upload,
+	} {
+		if got := s.deliveryType(key); got != want {
+			t.Errorf("deliveryType(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
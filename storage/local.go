@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalStore lưu file trên đĩa dưới root. File được phục vụ công khai qua ServeHTTP tại publicURL.
+type LocalStore struct {
+	root       string
+	publicURL  string
+	signingKey []byte
+}
+
+// NewLocalStore tạo store trên thư mục root. signingKey rỗng thì sinh khóa ngẫu nhiên,
+// khi đó URL tạm thời hết hiệu lực sau khi khởi động lại.
+func NewLocalStore(root, publicURL, signingKey string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &LocalStore{root: root, publicURL: strings.TrimRight(publicURL, "/"), signingKey: key}, nil
+}
+
+func (s *LocalStore) path(key string) (string, string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return key, filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStore) object(key string, info fs.FileInfo) *Object {
+	return &Object{
+		Key:         key,
+		URL:         s.publicURL + "/" + key,
+		ContentType: contentTypeFor(key),
+		Size:        info.Size(),
+		ModTime:     info.ModTime().UTC(),
+	}
+}
+
+// Put ghi ra file tạm rồi đổi tên để người đọc không thấy file ghi dở
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*Object, error) {
+	key, name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return nil, err
+	}
+	return s.Stat(ctx, key)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	key, name, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, s.object(key, info), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	_, name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (*Object, error) {
+	key, name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.object(key, info), nil
+}
+
+// SignedURL trả về URL công khai kèm chữ ký HMAC và thời điểm hết hạn
+func (s *LocalStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{"expires": {expires}, "sig": {s.sign(key, expires)}}
+	return s.publicURL + "/" + key + "?" + q.Encode(), nil
+}
+
+// sign ký các giá trị nối bằng "\n": key, expires cho URL đọc, thêm method, content type, size cho URL upload
+func (s *LocalStore) sign(fields ...string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PresignUpload trả về URL PUT tới chính server (ServeHTTP), chữ ký ràng buộc key, Content-Type và dung lượng tối đa
+func (s *LocalStore) PresignUpload(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (*UploadTicket, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(ttl).UTC()
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	sizeStr := strconv.FormatInt(size, 10)
+	q := url.Values{
+		"expires": {expires},
+		"size":    {sizeStr},
+		"sig":     {s.sign(key, expires, http.MethodPut, contentType, sizeStr)},
+	}
+	return &UploadTicket{
+		Method:    http.MethodPut,
+		URL:       s.publicURL + "/" + key + "?" + q.Encode(),
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// servePut nhận file upload trực tiếp theo URL do PresignUpload cấp
+func (s *LocalStore) servePut(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	expires, sizeStr, contentType := q.Get("expires"), q.Get("size"), r.Header.Get("Content-Type")
+	exp, err1 := strconv.ParseInt(expires, 10, 64)
+	size, err2 := strconv.ParseInt(sizeStr, 10, 64)
+	sig := s.sign(key, expires, http.MethodPut, contentType, sizeStr)
+	if err1 != nil || err2 != nil || time.Now().Unix() > exp || !hmac.Equal([]byte(q.Get("sig")), []byte(sig)) {
+		http.Error(w, "invalid or expired signature", http.StatusForbidden)
+		return
+	}
+	if r.ContentLength > size {
+		http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, size)
+	if _, err := s.Put(r.Context(), key, body, PutOptions{ContentType: contentType}); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "cannot store file", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *LocalStore) KeyFromURL(u string) (string, bool) {
+	rest, ok := strings.CutPrefix(u, s.publicURL+"/")
+	if !ok || rest == "" {
+		return "", false
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	return rest, true
+}
+
+// ServeHTTP phục vụ file theo đường dẫn (đã bỏ prefix) là key. File là công khai như URL của Cloudinary;
+// request có tham số sig thì chữ ký và thời hạn phải hợp lệ. File dưới StagingPrefix chỉ đọc được bằng URL ký.
+// PUT nhận file theo URL của PresignUpload.
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, name, err := s.path(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodPut {
+		s.servePut(w, r, key)
+		return
+	}
+
+	q := r.URL.Query()
+	if sig := q.Get("sig"); sig != "" {
+		expires := q.Get("expires")
+		exp, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > exp || !hmac.Equal([]byte(sig), []byte(s.sign(key, expires))) {
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+	} else if strings.HasPrefix(key, StagingPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeFor(key))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, key, info.ModTime(), f)
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreStagingIsNotPublic(t *testing.T) {
+	s, err := NewLocalStore(t.TempDir(), "http://blobs.test", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{StagingPrefix + "a.jpg", "images/a.jpg"} {
+		if _, err := s.Put(t.Context(), key, strings.NewReader("data"), PutOptions{ContentType: "image/jpeg"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signed, err := s.SignedURL(t.Context(), StagingPrefix+"a.jpg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodGet, "/images/a.jpg", http.StatusOK},
+		{http.MethodGet, "/" + StagingPrefix + "a.jpg", http.StatusNotFound},
+		{http.MethodHead, "/" + StagingPrefix + "a.jpg", http.StatusNotFound},
+		{http.MethodGet, "/" + StagingPrefix + "a.jpg?sig=bad&expires=9999999999", http.StatusForbidden},
+		{http.MethodGet, strings.TrimPrefix(signed, "http://blobs.test"), http.StatusOK},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.target, w.Code, tc.status)
+		}
+	}
+}
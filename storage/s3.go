@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config là thông tin kết nối tới S3 hoặc dịch vụ tương thích
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PublicURL là URL công khai của bucket (vd CDN), mặc định <Endpoint>/<Bucket>
+	PublicURL string
+}
+
+// S3Store lưu object trên S3 theo path-style (<endpoint>/<bucket>/<key>), request được ký bằng AWS Signature V4.
+// URL lưu trong dữ liệu là PublicURL nên bucket (hoặc CDN phía trước) cần cho phép đọc công khai, trừ StagingPrefix.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	if cfg.PublicURL == "" {
+		cfg.PublicURL = endpoint.String() + "/" + cfg.Bucket
+	}
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+	return &S3Store{cfg: cfg, endpoint: endpoint}, nil
+}
+
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = "/" + s.cfg.Bucket + "/" + key
+	u.RawPath = "/" + s.cfg.Bucket + "/" + s3Escape(key, false)
+	return &u
+}
+
+func (s *S3Store) publicURL(key string) string {
+	return s.cfg.PublicURL + "/" + s3Escape(key, false)
+}
+
+// do gửi request đã ký tới object key. Body được đọc hết vào bộ nhớ vì S3 cần Content-Length và hash của payload.
+func (s *S3Store) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.signRequest(req, body, time.Now().UTC())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+func (s *S3Store) object(key string, h http.Header) *Object {
+	obj := &Object{Key: key, URL: s.publicURL(key), ContentType: h.Get("Content-Type")}
+	obj.Size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if t, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		obj.ModTime = t.UTC()
+	}
+	return obj
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*Object, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = contentTypeFor(key)
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &Object{
+		Key:         key,
+		URL:         s.publicURL(key),
+		ContentType: contentType,
+		Size:        int64(len(body)),
+		ModTime:     time.Now().UTC(),
+	}, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, s.object(key, resp.Header), nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*Object, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return s.object(key, resp.Header), nil
+}
+
+// SignedURL trả về presigned GET URL, ttl tối đa 7 ngày theo giới hạn của S3
+func (s *S3Store) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return s.presign(http.MethodGet, key, ttl, time.Now().UTC(), nil), nil
+}
+
+// PresignUpload trả về presigned PUT URL. Content-Type và Content-Length nằm trong chữ ký
+// nên client phải gửi đúng hai header này, S3 từ chối file khác dung lượng đã khai báo.
+func (s *S3Store) PresignUpload(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (*UploadTicket, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{
+		"content-type":   contentType,
+		"content-length": strconv.FormatInt(size, 10),
+	}
+	now := time.Now().UTC()
+	return &UploadTicket{
+		Method:    http.MethodPut,
+		URL:       s.presign(http.MethodPut, key, ttl, now, headers),
+		Headers:   map[string]string{"Content-Type": contentType, "Content-Length": headers["content-length"]},
+		ExpiresAt: now.Add(min(max(ttl, time.Second), s3MaxPresignTTL)),
+	}, nil
+}
+
+func (s *S3Store) KeyFromURL(u string) (string, bool) {
+	rest, ok := strings.CutPrefix(u, s.cfg.PublicURL+"/")
+	if !ok || rest == "" {
+		return "", false
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	key, err := url.PathUnescape(rest)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+// ---- AWS Signature Version 4 ----
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3MaxPresignTTL   = 7 * 24 * time.Hour
+)
+
+// signRequest ký request bằng header Authorization
+func (s *S3Store) signRequest(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	canonicalHeaders, signedHeaders := s3CanonicalHeaders(headers)
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, scope, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// presign tạo URL ký bằng query string. Header được ký gồm host và headers (tên viết thường),
+// client phải gửi đúng các header này.
+func (s *S3Store) presign(method, key string, ttl time.Duration, now time.Time, headers map[string]string) string {
+	return s.presignURL(method, s.objectURL(key), ttl, now, headers)
+}
+
+func (s *S3Store) presignURL(method string, u *url.URL, ttl time.Duration, now time.Time, headers map[string]string) string {
+	ttl = min(max(ttl, time.Second), s3MaxPresignTTL)
+	scope := s.scope(now)
+
+	signed := map[string]string{"host": u.Host}
+	maps.Copy(signed, headers)
+	canonicalHeaders, signedHeaders := s3CanonicalHeaders(signed)
+
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", s3Algorithm)
+	q.Set("X-Amz-Credential", s.cfg.AccessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	q.Set("X-Amz-SignedHeaders", signedHeaders)
+
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		s3CanonicalQuery(q),
+		canonicalHeaders,
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+	q.Set("X-Amz-Signature", s.signature(now, scope, canonical))
+
+	u.RawQuery = s3CanonicalQuery(q)
+	return u.String()
+}
+
+func (s *S3Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+func (s *S3Store) signature(now time.Time, scope, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func s3CanonicalHeaders(headers map[string]string) (canonical, signed string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape mã hóa theo RFC 3986 như S3 yêu cầu; encodeSlash = false giữ nguyên "/" trong đường dẫn
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
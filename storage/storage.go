@@ -0,0 +1,128 @@
+// Package storage trừu tượng hóa nơi lưu file (ảnh, model) sau interface BlobStore,
+// backend được chọn theo config.BlobBackend.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"backend/config"
+)
+
+// ErrNotFound trả về khi object không tồn tại
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey trả về khi key rỗng hoặc thoát ra ngoài thư mục gốc
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Object mô tả một file đã lưu. Key là đường dẫn dạng "images/abc.jpg", URL là địa chỉ công khai để lưu vào dữ liệu.
+type Object struct {
+	Key         string    `json:"key"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time,omitempty"`
+}
+
+// PutOptions là tùy chọn khi ghi object
+type PutOptions struct {
+	// ContentType mặc định đoán theo phần mở rộng của key
+	ContentType string
+}
+
+// BlobStore là nơi lưu file. Ghi vào key đã tồn tại sẽ ghi đè.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*Object, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Delete không lỗi nếu object không tồn tại
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*Object, error)
+	// SignedURL trả về URL đọc object có hạn ttl (backend có URL công khai có thể bỏ qua ttl)
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// KeyFromURL lấy key từ URL do store này cấp, false nếu URL không thuộc store
+	KeyFromURL(url string) (string, bool)
+}
+
+// UploadTicket là thông tin để client tự gửi file thẳng lên store mà không đi qua server
+type UploadTicket struct {
+	// PUT: gửi nội dung file làm body kèm Headers; POST: multipart form gồm Fields và file ở field "file"
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// StagingPrefix là prefix của file client upload trực tiếp (DirectUploader) chưa được xác nhận.
+// File dưới prefix này chưa được kiểm tra và còn metadata gốc nên không được phục vụ công khai.
+const StagingPrefix = "uploads/"
+
+// DirectUploader là store cho phép client upload trực tiếp bằng tham số đã ký, chỉ ghi được đúng key
+// với Content-Type và dung lượng đã khai báo (nếu backend hỗ trợ ràng buộc) trước khi hết hạn ttl
+type DirectUploader interface {
+	PresignUpload(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (*UploadTicket, error)
+}
+
+// Default là store dùng chung của server, được khởi tạo bởi Init
+var Default BlobStore
+
+// Init tạo store theo config.BlobBackend
+func Init() {
+	var err error
+	switch config.BlobBackend {
+	case "cloudinary":
+		config.InitCloudinary()
+		Default = NewCloudinaryStore(config.CLD)
+	case "local":
+		Default, err = NewLocalStore(config.BlobLocalDir, config.BlobPublicURL, config.BlobSigningKey)
+	case "s3":
+		Default, err = NewS3Store(S3Config{
+			Endpoint:        config.S3Endpoint,
+			Region:          config.S3Region,
+			Bucket:          config.S3Bucket,
+			AccessKeyID:     config.S3AccessKeyID,
+			SecretAccessKey: config.S3SecretAccessKey,
+			PublicURL:       config.S3PublicURL,
+		})
+	default:
+		err = fmt.Errorf("unknown BLOB_BACKEND %q", config.BlobBackend)
+	}
+	if err != nil {
+		log.Fatalf("Failed to init blob store: %v", err)
+	}
+	log.Printf("Blob store initialized (%s)", config.BlobBackend)
+}
+
+func init() {
+	// Bảng MIME của hệ điều hành có thể thiếu các định dạng ảnh mới
+	mime.AddExtensionType(".webp", "image/webp")
+	mime.AddExtensionType(".heic", "image/heic")
+}
+
+// contentTypeFor đoán Content-Type theo phần mở rộng của key
+func contentTypeFor(key string) string {
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// cleanKey chuẩn hóa key (bỏ "..", "/" thừa) để không thoát ra ngoài thư mục gốc, từ chối key rỗng
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	if key == "" || key == "." {
+		return "", ErrInvalidKey
+	}
+	return key, nil
+}
+
+// httpClient dùng cho các backend truy cập qua HTTP
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
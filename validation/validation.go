@@ -0,0 +1,137 @@
+// Package validation kiểm tra dữ liệu request theo rule khai báo trong tag `binding` của model
+// (dùng chung validator engine với gin) và chuyển lỗi thành apperr.FieldError.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"backend/apperr"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// idPattern là tập ký tự cho phép của ID do client đặt (vd ID của WoodDatabase)
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+var registerOnce sync.Once
+
+// engine trả về validator của gin sau khi đăng ký rule riêng của repo
+func engine() *validator.Validate {
+	v := binding.Validator.Engine().(*validator.Validate)
+	registerOnce.Do(func() {
+		v.RegisterTagNameFunc(jsonFieldName)
+		_ = v.RegisterValidation("libid", func(fl validator.FieldLevel) bool {
+			return idPattern.MatchString(fl.Field().String())
+		})
+	})
+	return v
+}
+
+// jsonFieldName để lỗi trả về dùng tên field JSON thay vì tên field Go
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// Struct kiểm tra toàn bộ struct, trả về *apperr.Error (400) nếu có field không hợp lệ
+func Struct(obj interface{}) error {
+	err := engine().Struct(obj)
+	if err == nil {
+		return nil
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+
+	details := make([]apperr.FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		// Namespace có dạng WoodPiece.image_urls[0], bỏ tên struct ở đầu
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		details = append(details, fieldError(field, fe))
+	}
+	return apperr.Validation(details...)
+}
+
+// Field kiểm tra một giá trị theo rule của field tương ứng trong model (dùng cho PATCH).
+// structField là tên field Go, name là tên field trả về trong lỗi.
+func Field(model interface{}, structField, name string, value interface{}) error {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	f, ok := t.FieldByName(structField)
+	if !ok {
+		return nil
+	}
+	tag := f.Tag.Get("binding")
+	if tag == "" {
+		return nil
+	}
+
+	if value == nil {
+		if strings.Contains(","+tag+",", ",required,") {
+			return apperr.Validation(apperr.FieldError{Field: name, Code: "required", Message: name + " is required"})
+		}
+		return nil
+	}
+
+	err := engine().Var(value, tag)
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+
+	details := make([]apperr.FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		// Với slice, Namespace của phần tử lỗi có dạng [0]
+		details = append(details, fieldError(name+fe.Namespace(), fe))
+	}
+	return apperr.Validation(details...)
+}
+
+func fieldError(field string, fe validator.FieldError) apperr.FieldError {
+	return apperr.FieldError{Field: field, Code: fe.Tag(), Message: field + " " + describe(fe)}
+}
+
+// describe sinh thông báo lỗi dễ đọc cho từng rule
+func describe(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at most %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at least %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s characters", fe.Param())
+	case "http_url":
+		return "must be a valid http(s) URL"
+	case "libid":
+		return "may only contain letters, digits, '_' and '-' and must start with a letter or digit"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	default:
+		return fmt.Sprintf("failed '%s' validation", fe.Tag())
+	}
+}